@@ -0,0 +1,28 @@
+package modbridge
+
+// Availability payloads, as expected by Home Assistant by default
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// offlineAfter is the number of consecutive failed polls after which a device is announced as offline
+const offlineAfter = 3
+
+// deviceAvailabilityTopic renders the availability topic template of the device, empty when not set
+func (c *Configuration) deviceAvailabilityTopic() string {
+	if c.DeviceAvailabilityTopic == "" {
+		return ""
+	}
+	return c.topic(c.DeviceAvailabilityTopic, "")
+}
+
+// availabilityTopics lists both the availability topic of the bridge and the one of the device, when set
+func (c *Configuration) availabilityTopics() (topics []string) {
+	for _, topic := range []string{c.AvailabilityTopic, c.deviceAvailabilityTopic()} {
+		if topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package modbridge
+
+import (
+	"reflect"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mhemeryck/modbridge/mocks"
+)
+
+func TestAvailabilityTopics(t *testing.T) {
+	cases := []struct {
+		c        Configuration
+		expected []string
+	}{
+		{c: Configuration{}, expected: nil},
+		{c: Configuration{AvailabilityTopic: "modbridge/status"}, expected: []string{"modbridge/status"}},
+		{
+			c:        Configuration{TopicPrefix: "modbridge", Device: "neuron", AvailabilityTopic: "modbridge/status", DeviceAvailabilityTopic: "{prefix}/{device}/status"},
+			expected: []string{"modbridge/status", "modbridge/neuron/status"},
+		},
+	}
+	for _, testCase := range cases {
+		if topics := testCase.c.availabilityTopics(); !reflect.DeepEqual(topics, testCase.expected) {
+			t.Errorf("Expected availability topics %v, got %v\n", testCase.expected, topics)
+		}
+	}
+}
+
+func TestDeviceAvailability(t *testing.T) {
+	mqttClient := &mocks.MQTTClient{}
+	device := &Device{MQTTClient: mqttClient, availabilityTopic: "neuron/status"}
+	mqttClient.On("Publish", "neuron/status", byte(1), true, Online).Return(&mqtt.PublishToken{}).Once()
+	mqttClient.On("Publish", "neuron/status", byte(1), true, Offline).Return(&mqtt.PublishToken{}).Once()
+
+	// Online once, only going offline after consecutive failures
+	device.setAvailable(true)
+	device.setAvailable(true)
+	for k := 0; k < offlineAfter-1; k++ {
+		device.setAvailable(false)
+	}
+	if device.availability != Online {
+		t.Errorf("Expected the device to stay online, got %s\n", device.availability)
+	}
+	device.setAvailable(false)
+	device.setAvailable(false)
+	if device.availability != Offline {
+		t.Errorf("Expected the device to go offline, got %s\n", device.availability)
+	}
+	mqttClient.AssertNumberOfCalls(t, "Publish", 2)
+}
+
+func TestDevicePublishAvailability(t *testing.T) {
+	mqttClient := &mocks.MQTTClient{}
+	device := &Device{MQTTClient: mqttClient, availabilityTopic: "neuron/status"}
+
+	// Nothing is known before the first poll
+	device.PublishAvailability(mqttClient)
+	mqttClient.AssertNumberOfCalls(t, "Publish", 0)
+
+	// Republished on every reconnect, even without any change
+	mqttClient.On("Publish", "neuron/status", byte(1), true, Online).Return(&mqtt.PublishToken{}).Times(3)
+	device.setAvailable(true)
+	device.PublishAvailability(mqttClient)
+	device.PublishAvailability(mqttClient)
+	mqttClient.AssertExpectations(t)
+}
@@ -0,0 +1,42 @@
+package modbridge
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing delays with a random jitter between reconnection attempts
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+	attempt int
+	random  func() float64
+}
+
+// NewBackoff creates a backoff going from half a second up to 30 seconds, doubling each attempt with a 20% jitter
+func NewBackoff() *Backoff {
+	return &Backoff{Min: 500 * time.Millisecond, Max: 30 * time.Second, Factor: 2, Jitter: 0.2, random: rand.Float64}
+}
+
+// Next returns the delay before the next attempt
+func (backoff *Backoff) Next() time.Duration {
+	delay := float64(backoff.Min) * math.Pow(backoff.Factor, float64(backoff.attempt))
+	if delay > float64(backoff.Max) {
+		delay = float64(backoff.Max)
+	} else {
+		backoff.attempt++
+	}
+	// Spread the delay evenly within the jitter fraction around it
+	if backoff.random != nil {
+		delay *= 1 + backoff.Jitter*(2*backoff.random()-1)
+	}
+	return time.Duration(delay)
+}
+
+// Reset starts over from the minimum delay, after a successful attempt
+func (backoff *Backoff) Reset() {
+	backoff.attempt = 0
+}
@@ -0,0 +1,37 @@
+package modbridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	backoff := Backoff{Min: time.Second, Max: 5 * time.Second, Factor: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for _, delay := range expected {
+		if result := backoff.Next(); result != delay {
+			t.Errorf("Expected delay %s, got %s\n", delay, result)
+		}
+	}
+	backoff.Reset()
+	if result := backoff.Next(); result != time.Second {
+		t.Errorf("Expected delay to restart from %s after a reset, got %s\n", time.Second, result)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	cases := []struct {
+		random   float64
+		expected time.Duration
+	}{
+		{random: 0, expected: 800 * time.Millisecond},
+		{random: 0.5, expected: time.Second},
+		{random: 1, expected: 1200 * time.Millisecond},
+	}
+	for _, testCase := range cases {
+		backoff := Backoff{Min: time.Second, Max: time.Minute, Factor: 2, Jitter: 0.2, random: func() float64 { return testCase.random }}
+		if result := backoff.Next(); result != testCase.expected {
+			t.Errorf("Expected delay %s, got %s\n", testCase.expected, result)
+		}
+	}
+}
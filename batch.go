@@ -0,0 +1,230 @@
+package modbridge
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Maximum number of coils and registers the modbus protocol allows to write at once
+const (
+	MaxWriteCoils     = 1968
+	MaxWriteRegisters = 123
+)
+
+// batch holds the topics for writing many points of a device at once
+type batch struct {
+	topic      string
+	errorTopic string
+	qos        byte
+}
+
+// batch renders the batch topic of the device, along with the error topic for the device as a whole
+func (c *Configuration) batch() *batch {
+	if c.BatchTopic == "" {
+		return nil
+	}
+	qos, _ := c.PublishConfig.resolve(PublishConfig{})
+	return &batch{
+		topic:      c.topic(c.BatchTopic, ""),
+		errorTopic: c.topic(c.TopicConfig.resolve(TopicConfig{}).ErrorTopic, ""),
+		qos:        qos,
+	}
+}
+
+// batchWrite is a single coil or holding register written as part of a batch
+type batchWrite struct {
+	command     *command
+	coilCommand CoilCommand
+	on          bool
+	data        []byte
+}
+
+// address returns the address of the point written
+func (write *batchWrite) address() uint16 {
+	if write.command.coil != nil {
+		return write.command.coil.Address
+	}
+	return write.command.register.Address
+}
+
+// unquote returns the contents of a JSON string, or the raw JSON for any other value
+func unquote(raw json.RawMessage) []byte {
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return []byte(value)
+	}
+	return raw
+}
+
+// parseBatch interprets a batch payload, mapping the slugs of the points to their command payloads like {"relay-1":"ON","setpoint":21.5}.
+// Any unknown point, invalid payload or overlapping registers reject the whole batch.
+func (device *Device) parseBatch(payload []byte) (coils []*batchWrite, registers []*batchWrite, err error) {
+	var values map[string]json.RawMessage
+	if err = json.Unmarshal(payload, &values); err != nil {
+		return nil, nil, fmt.Errorf("invalid batch %q: %s", payload, err)
+	}
+	commands := make(map[string]*command)
+	for _, command := range device.commands {
+		commands[command.slug()] = command
+	}
+	for slug, raw := range values {
+		command, ok := commands[slug]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown point %s in batch", slug)
+		}
+		write := &batchWrite{command: command}
+		if command.coil != nil {
+			write.coilCommand, err = ParseCoilCommand(unquote(raw), command.coil.payloads)
+			if err == nil {
+				err = command.allowCoilCommand(write.coilCommand)
+			}
+			coils = append(coils, write)
+		} else {
+			write.data, err = command.register.encode(string(unquote(raw)))
+			registers = append(registers, write)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", slug, err)
+		}
+	}
+	sort.Slice(coils, func(i, j int) bool { return coils[i].address() < coils[j].address() })
+	sort.Slice(registers, func(i, j int) bool { return registers[i].address() < registers[j].address() })
+	for k := 1; k < len(registers); k++ {
+		previous := registers[k-1].command.register
+		if int(registers[k].address()) < int(previous.Address)+int(previous.Size()) {
+			return nil, nil, fmt.Errorf("overlapping registers %s and %s in batch", previous.Slug, registers[k].command.register.Slug)
+		}
+	}
+	return
+}
+
+// handleBatch writes all of the points in a batch payload, coalescing contiguous addresses into as few requests as possible
+func (device *Device) handleBatch(payload []byte) error {
+	coils, registers, err := device.parseBatch(payload)
+	if err != nil {
+		return err
+	}
+	// Nothing is written when any of the points is read-only, or when the device is unreachable
+	for _, write := range append(append([]*batchWrite{}, coils...), registers...) {
+		if err := device.allowWrite(write.command); err == ErrReadOnly {
+			return fmt.Errorf("%s: %s", write.command.slug(), err)
+		} else if err != nil {
+			return err
+		}
+	}
+	// Hold on to the coils for the whole batch, locking in address order
+	for _, write := range coils {
+		write.command.mu.Lock()
+		defer write.command.mu.Unlock()
+	}
+	for _, write := range coils {
+		state := write.coilCommand.State
+		if state == StateToggle {
+			on, err := device.readCoil(write.command.coil)
+			if err != nil {
+				return err
+			}
+			state = StateOn
+			if on {
+				state = StateOff
+			}
+		}
+		write.on = state == StateOn
+		if write.command.pulse != nil {
+			write.command.pulse.timer.Stop()
+			write.command.pulse = nil
+		}
+	}
+	if err := device.writeCoils(coils); err != nil {
+		return err
+	}
+	for _, write := range coils {
+		if duration := write.coilCommand.Duration; write.on && (duration > 0 || write.command.autoOff > 0) {
+			if duration == 0 {
+				duration = write.command.autoOff
+			}
+			device.startPulse(write.command, duration)
+		}
+	}
+	if err := device.writeRegisters(registers); err != nil {
+		return err
+	}
+	// Confirm the points which ask for it, only after all of the writes went through
+	for _, write := range coils {
+		if write.command.confirm {
+			if err := device.confirmCoil(write.command.coil, write.on); err != nil {
+				return err
+			}
+		}
+	}
+	for _, write := range registers {
+		if write.command.confirm {
+			if err := device.confirmRegister(write.command.register, write.data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeCoils writes runs of contiguous coils with a single request each
+func (device *Device) writeCoils(coils []*batchWrite) error {
+	for start := 0; start < len(coils); {
+		end := start + 1
+		for end < len(coils) && end-start < MaxWriteCoils && int(coils[end].address()) == int(coils[end-1].address())+1 {
+			end++
+		}
+		run := coils[start:end]
+		var err error
+		if len(run) == 1 {
+			var value uint16
+			if run[0].command.coil.invert(run[0].on) {
+				value = 0xFF00
+			}
+			_, err = device.ModbusClient.WriteSingleCoil(run[0].address(), value)
+		} else {
+			values := make([]byte, (len(run)+7)/8)
+			for k, write := range run {
+				if write.command.coil.invert(write.on) {
+					values[k/8] |= 1 << uint(k%8)
+				}
+			}
+			_, err = device.ModbusClient.WriteMultipleCoils(run[0].address(), uint16(len(run)), values)
+		}
+		if err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// writeRegisters writes runs of contiguous holding registers with a single request each, which don't overlap after parsing
+func (device *Device) writeRegisters(registers []*batchWrite) error {
+	for start := 0; start < len(registers); {
+		data := registers[start].data
+		end := start + 1
+		for end < len(registers) {
+			previous := registers[end-1].command.register
+			next := int(previous.Address) + int(previous.Size())
+			if int(registers[end].address()) != next || len(data)/2+len(registers[end].data)/2 > MaxWriteRegisters {
+				break
+			}
+			data = append(append([]byte{}, data...), registers[end].data...)
+			end++
+		}
+		var err error
+		if len(data) == 2 {
+			_, err = device.ModbusClient.WriteSingleRegister(registers[start].address(), binary.BigEndian.Uint16(data))
+		} else {
+			_, err = device.ModbusClient.WriteMultipleRegisters(registers[start].address(), uint16(len(data)/2), data)
+		}
+		if err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
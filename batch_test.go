@@ -0,0 +1,137 @@
+package modbridge
+
+import (
+	"errors"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mhemeryck/modbridge/mocks"
+)
+
+// batchDevice sets up a device with a few coils and holding registers to write in a batch
+func batchDevice(modbusClient *mocks.ModbusClient, mqttClient *mocks.MQTTClient) *Device {
+	return &Device{
+		ModbusClient: modbusClient,
+		MQTTClient:   mqttClient,
+		batch:        &batch{topic: "neuron/set", errorTopic: "neuron/error"},
+		commands: map[string]*command{
+			"relay-1/set":  {coil: &Coil{Address: 1, Slug: "relay-1"}},
+			"relay-2/set":  {coil: &Coil{Address: 2, Slug: "relay-2"}},
+			"relay-3/set":  {coil: &Coil{Address: 3, Slug: "relay-3"}},
+			"relay-9/set":  {coil: &Coil{Address: 9, Slug: "relay-9"}},
+			"sensor/set":   {mode: Read, coil: &Coil{Address: 10, Slug: "sensor"}},
+			"output/set":   {mode: Write, coil: &Coil{Address: 11, Slug: "output"}},
+			"setpoint/set": {register: &Register{Address: 4, Slug: "setpoint", scale: 0.1}},
+			"limit/set":    {register: &Register{Address: 5, Slug: "limit"}},
+			"total/set":    {register: &Register{Address: 6, Slug: "total", dataType: Uint32}},
+			"mode/set":     {register: &Register{Address: 20, Slug: "mode"}},
+			"counter/set":  {register: &Register{Address: 21, Slug: "counter", dataType: Int32}},
+			"low/set":      {register: &Register{Address: 22, Slug: "low"}},
+		},
+	}
+}
+
+func TestDeviceHandleBatch(t *testing.T) {
+	cases := []struct {
+		payload string
+		calls   [][]interface{}
+		valid   bool
+	}{
+		{
+			payload: `{"relay-1":"ON","relay-2":"OFF","relay-3":true}`,
+			calls:   [][]interface{}{{"WriteMultipleCoils", uint16(1), uint16(3), []byte{5}}},
+			valid:   true,
+		},
+		{
+			payload: `{"relay-1":"ON","relay-9":"ON"}`,
+			calls: [][]interface{}{
+				{"WriteSingleCoil", uint16(1), uint16(0xFF00)},
+				{"WriteSingleCoil", uint16(9), uint16(0xFF00)},
+			},
+			valid: true,
+		},
+		{
+			payload: `{"setpoint":21.5,"limit":"3","total":65536}`,
+			calls:   [][]interface{}{{"WriteMultipleRegisters", uint16(4), uint16(4), []byte{0, 215, 0, 3, 0, 1, 0, 0}}},
+			valid:   true,
+		},
+		{
+			payload: `{"limit":3,"mode":1,"relay-2":"OFF"}`,
+			calls: [][]interface{}{
+				{"WriteSingleCoil", uint16(2), uint16(0)},
+				{"WriteSingleRegister", uint16(5), uint16(3)},
+				{"WriteSingleRegister", uint16(20), uint16(1)},
+			},
+			valid: true,
+		},
+		{payload: `{"relay-1":"ON","sensor":"ON"}`, valid: false},
+		{payload: `{"relay-1":"ON","output":"TOGGLE"}`, valid: false},
+		{payload: `{"relay-1":"ON","unknown":"ON"}`, valid: false},
+		{payload: `{"relay-1":"ON","limit":"high"}`, valid: false},
+		{payload: `{"relay-1":"ON","counter":1,"low":2}`, valid: false},
+		{payload: `ON`, valid: false},
+	}
+	for _, testCase := range cases {
+		modbusClient := &mocks.ModbusClient{}
+		device := batchDevice(modbusClient, &mocks.MQTTClient{})
+		for _, call := range testCase.calls {
+			modbusClient.On(call[0].(string), call[1:]...).Return([]byte{}, nil).Once()
+		}
+		if err := device.handle("neuron/set", []byte(testCase.payload)); (err == nil) != testCase.valid {
+			t.Errorf("Expected valid %v for %s, got %v\n", testCase.valid, testCase.payload, err)
+		}
+		modbusClient.AssertExpectations(t)
+	}
+}
+
+func TestDeviceHandleBatchToggle(t *testing.T) {
+	modbusClient := &mocks.ModbusClient{}
+	device := batchDevice(modbusClient, &mocks.MQTTClient{})
+	modbusClient.On("ReadCoils", uint16(2), uint16(1)).Return([]byte{1}, nil)
+	modbusClient.On("WriteMultipleCoils", uint16(1), uint16(2), []byte{1}).Return([]byte{}, nil)
+	if err := device.handle("neuron/set", []byte(`{"relay-1":"ON","relay-2":"TOGGLE"}`)); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+	modbusClient.AssertExpectations(t)
+}
+
+func TestDeviceHandleBatchUnreachable(t *testing.T) {
+	device := batchDevice(&mocks.ModbusClient{}, &mocks.MQTTClient{})
+	device.unreachable = true
+	if err := device.handle("neuron/set", []byte(`{"relay-1":"ON"}`)); err != ErrUnreachable {
+		t.Errorf("Expected %v, got %v\n", ErrUnreachable, err)
+	}
+}
+
+func TestDeviceHandleBatchMessageError(t *testing.T) {
+	modbusClient := &mocks.ModbusClient{}
+	mqttClient := &mocks.MQTTClient{}
+	device := batchDevice(modbusClient, mqttClient)
+	modbusClient.On("WriteSingleCoil", uint16(1), uint16(0xFF00)).Return([]byte{}, errors.New("timeout"))
+	mqttClient.On("Publish", "neuron/error", byte(0), false, "timeout").Return(&mqtt.PublishToken{})
+	device.HandleMessage(mqttClient, &message{topic: "neuron/set", payload: []byte(`{"relay-1":"ON"}`)})
+	mqttClient.AssertExpectations(t)
+}
+
+func TestConfigurationBatch(t *testing.T) {
+	cases := []struct {
+		c        Configuration
+		expected *batch
+	}{
+		{c: Configuration{TopicPrefix: "modbridge", Device: "neuron"}, expected: nil},
+		{
+			c:        Configuration{TopicPrefix: "modbridge", Device: "neuron", BatchTopic: "{prefix}/{device}/set"},
+			expected: &batch{topic: "modbridge/neuron/set", errorTopic: "modbridge/neuron/error"},
+		},
+		{
+			c:        Configuration{Device: "neuron", BatchTopic: "{device}/batch", TopicConfig: TopicConfig{ErrorTopic: "errors/{device}/{slug}"}},
+			expected: &batch{topic: "neuron/batch", errorTopic: "errors/neuron"},
+		},
+	}
+	for _, testCase := range cases {
+		result := testCase.c.batch()
+		if (result == nil) != (testCase.expected == nil) || (result != nil && *result != *testCase.expected) {
+			t.Errorf("Expected %v, got %v\n", testCase.expected, result)
+		}
+	}
+}
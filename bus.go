@@ -0,0 +1,56 @@
+package modbridge
+
+import (
+	"github.com/goburrow/modbus"
+)
+
+// Bus is a single modbus connection, shared by all of the devices on the same transport, like several slaves on one RS-485 line
+type Bus struct {
+	handler modbus.ClientHandler
+	queue   *Queue
+	slaveID *byte
+}
+
+// Client returns the client for a single slave on the bus.
+// Its requests are queued along with the ones of the other slaves, addressing the slave right before sending each of them.
+func (bus *Bus) Client(slaveID byte) modbus.Client {
+	return bus.queue.withPrepare(func() {
+		*bus.slaveID = slaveID
+	})
+}
+
+// Buses keeps a bus for each transport, so devices using the same serial port or TCP gateway share its connection
+type Buses map[string]*Bus
+
+// Bus returns the bus for the modbus server URI of a device, setting it up for the first device using the transport.
+// The other settings of the transport, like the timeout, are the ones of that first device.
+func (buses Buses) Bus(c *Configuration) (*Bus, byte, error) {
+	handler, err := c.ClientHandler()
+	if err != nil {
+		return nil, 0, err
+	}
+	slaveID := handlerSlaveID(handler)
+	key, err := transportKey(c.ModbusServerURI)
+	if err != nil {
+		return nil, 0, err
+	}
+	bus, ok := buses[key]
+	if !ok {
+		bus = &Bus{handler: handler, queue: NewQueue(modbus.NewClient(handler)), slaveID: slaveID}
+		buses[key] = bus
+	}
+	return bus, *slaveID, nil
+}
+
+// handlerSlaveID points to the slave ID setting of a client handler
+func handlerSlaveID(handler modbus.ClientHandler) *byte {
+	switch h := handler.(type) {
+	case *modbus.TCPClientHandler:
+		return &h.SlaveId
+	case *modbus.RTUClientHandler:
+		return &h.SlaveId
+	case *modbus.ASCIIClientHandler:
+		return &h.SlaveId
+	}
+	return new(byte)
+}
@@ -0,0 +1,66 @@
+package modbridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"github.com/mhemeryck/modbridge/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBusesShareTransport(t *testing.T) {
+	buses := make(Buses)
+	cases := []struct {
+		c       Configuration
+		slaveID byte
+	}{
+		{c: Configuration{ModbusServerURI: "rtu:///dev/ttyNS0?baud=9600&slave=2"}, slaveID: 2},
+		{c: Configuration{ModbusServerURI: "rtu:///dev/ttyNS0?baud=9600", UnitID: 3}, slaveID: 3},
+		{c: Configuration{ModbusServerURI: "rtu:///dev/ttyNS0?baud=9600"}, slaveID: 1},
+	}
+	var first *Bus
+	for _, testCase := range cases {
+		bus, slaveID, err := buses.Bus(&testCase.c)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v\n", err)
+		}
+		if first == nil {
+			first = bus
+		}
+		if bus != first {
+			t.Errorf("Expected %s to share the bus of the first device\n", testCase.c.ModbusServerURI)
+		}
+		if slaveID != testCase.slaveID {
+			t.Errorf("Expected slave ID %d for %v, got %d\n", testCase.slaveID, testCase.c, slaveID)
+		}
+	}
+	other, _, err := buses.Bus(&Configuration{ModbusServerURI: "rtu:///dev/ttyNS1?slave=2"})
+	if err != nil || other == first {
+		t.Errorf("Expected a separate bus for another port, got %v\n", err)
+	}
+	if len(buses) != 2 {
+		t.Errorf("Expected 2 buses, got %d\n", len(buses))
+	}
+}
+
+func TestBusClientSlaveID(t *testing.T) {
+	handler := modbus.NewRTUClientHandler("/dev/ttyNS0")
+	modbusClient := &mocks.ModbusClient{}
+	bus := &Bus{handler: handler, queue: NewQueue(modbusClient), slaveID: &handler.SlaveId}
+	var slaveIDs []byte
+	modbusClient.On("ReadCoils", uint16(0), uint16(1)).Run(func(args mock.Arguments) {
+		slaveIDs = append(slaveIDs, handler.SlaveId)
+	}).Return([]byte{0}, nil)
+
+	// Two devices on the same bus, polled in turn
+	first, second := bus.Client(2), bus.Client(3)
+	for _, client := range []modbus.Client{first, second, first} {
+		if _, err := client.ReadCoils(0, 1); err != nil {
+			t.Errorf("Expected no error, got %v\n", err)
+		}
+	}
+	if !reflect.DeepEqual(slaveIDs, []byte{2, 3, 2}) {
+		t.Errorf("Expected each request to address its own slave, got %v\n", slaveIDs)
+	}
+}
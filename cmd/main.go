@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/goburrow/modbus"
 	"github.com/mhemeryck/modbridge"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -66,7 +68,7 @@ func main() {
 	var configFile string
 	flag.StringVar(&configFile, "filename", "config.yml", "Config file name")
 	var pollingInterval int
-	flag.IntVar(&pollingInterval, "polling_interval", 20, "Polling interval for one coil group in millis")
+	flag.IntVar(&pollingInterval, "polling_interval", 20, "Polling interval for one coil or register group in millis")
 	var caFile string
 	flag.StringVar(&caFile, "cafile", "", "CA certificate used for MQTT TLS setup")
 	var insecure bool
@@ -89,25 +91,8 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error %s reading in config\n", err)
 	}
-	// modbus client
-	modbusClient := modbus.TCPClient(config.ModbusServerURI)
-
-	// MQTT client
-	coilMap := config.CoilsMap()
-	// Subcribe for each topic: create a callback for all of them
-	var messageHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-		if coil, ok := coilMap[msg.Topic()]; ok {
-			var value uint16
-			if string(msg.Payload()) == "ON" {
-				value = 0xFF00
-			} else {
-				value = 0x0000
-			}
-			_, err := modbusClient.WriteSingleCoil(coil.Address, value)
-			if err != nil {
-				log.Printf("Error %d writing on MQTT event", err)
-			}
-		}
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Error %s in config\n", err)
 	}
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(config.MQTTBrokerURI)
@@ -116,37 +101,85 @@ func main() {
 		tlsConfig := NewTLSConfig(caFile, insecure)
 		opts.SetTLSConfig(tlsConfig)
 	}
+	// The broker announces the bridge as offline when the connection drops
+	if config.AvailabilityTopic != "" {
+		opts.SetWill(config.AvailabilityTopic, modbridge.Offline, 1, true)
+	}
+	// Subscribe with the global quality of service level
+	var qos byte
+	if config.QoS != nil {
+		qos = byte(*config.QoS)
+	}
+	// Devices are set up before connecting, as the subscriptions are made from the connect handler
+	var devices []*modbridge.Device
+	deviceConfigs := config.DevicesList()
 	opts.OnConnect = func(c mqtt.Client) {
-		for slug := range coilMap {
-			if token := c.Subscribe(slug, 0, messageHandler); token.Wait() && token.Error() != nil {
-				log.Fatal(token.Error())
+		if config.AvailabilityTopic != "" {
+			c.Publish(config.AvailabilityTopic, 1, true, modbridge.Online)
+		}
+		for k, device := range devices {
+			// The broker may have lost the retained availability of the devices, e.g. after a restart
+			device.PublishAvailability(c)
+			// Commands are received on their own topics, separate from the state topics we publish on
+			for _, topic := range device.CommandTopics() {
+				if token := c.Subscribe(topic, qos, device.HandleMessage); token.Wait() && token.Error() != nil {
+					log.Fatal(token.Error())
+				}
+			}
+			// Announce all points to Home Assistant
+			if config.Discovery.Enabled {
+				if err := deviceConfigs[k].PublishDiscovery(c); err != nil {
+					log.Printf("Error %s publishing discovery payloads", err)
+				}
 			}
 		}
 	}
 	mqttClient := mqtt.NewClient(opts)
+
+	// Optional snapshot of the coil values, kept across restarts
+	var snapshot *modbridge.Snapshot
+	if config.SnapshotFile != "" {
+		snapshot, err = modbridge.LoadSnapshot(config.SnapshotFile)
+		if err != nil {
+			log.Fatalf("Error %s reading snapshot %s\n", err, config.SnapshotFile)
+		}
+	}
+
+	// modbus clients, either over TCP or a serial line, shared by the devices on the same one
+	buses := make(modbridge.Buses)
+	for k := range deviceConfigs {
+		device, err := modbridge.NewDevice(&deviceConfigs[k], buses, mqttClient)
+		if err != nil {
+			log.Fatalf("Error %s setting up modbus client for %s\n", err, deviceConfigs[k].Device)
+		}
+		if snapshot != nil {
+			device.UseSnapshot(snapshot)
+		}
+		for _, block := range device.ReadBlocks() {
+			log.Printf("Reading %s from %s\n", block, deviceConfigs[k].Device)
+		}
+		devices = append(devices, device)
+	}
+
 	token := mqttClient.Connect()
 	if token.Wait() && token.Error() != nil {
 		log.Fatalf("Can't connect to MQTT host\n")
 	}
 
-	coilGroups := config.CoilGroupsList()
-	// Attach a copy of the clients to each of the groups
-	for k := range coilGroups {
-		coilGroups[k].ModbusClient = modbusClient
-		coilGroups[k].MQTTClient = mqttClient
+	// Continuous infinite polling, each device in its own loop
+	for _, device := range devices {
+		go device.Poll(time.Millisecond * time.Duration(pollingInterval))
+	}
+	if snapshot == nil {
+		select {}
 	}
 
-	ticker := time.NewTicker(time.Millisecond * time.Duration(pollingInterval)).C
-	// Continuous infinite polling
-	k := 0
-	for {
-		select {
-		case <-ticker:
-			err := coilGroups[k].Update()
-			if err != nil {
-				log.Fatal(err)
-			}
-			k = (k + 1) % len(coilGroups)
-		}
+	// Save the snapshot regularly, as well as right before stopping
+	go snapshot.SaveEvery(time.Minute)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	if err := snapshot.Save(); err != nil {
+		log.Fatalf("Error %s saving snapshot %s\n", err, config.SnapshotFile)
 	}
 }
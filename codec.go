@@ -0,0 +1,83 @@
+package modbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CoilState is the state requested for a coil by a command payload
+type CoilState int
+
+// Coil state constants
+const (
+	StateOff    CoilState = iota // Switch the coil off
+	StateOn                      // Switch the coil on
+	StateToggle                  // Invert the current value of the coil
+)
+
+// CoilCommand is a command for a coil, switching it back off after the duration when set
+type CoilCommand struct {
+	State    CoilState
+	Duration time.Duration
+}
+
+// jsonCoilCommand holds the JSON form of a coil command payload
+type jsonCoilCommand struct {
+	State    interface{} `json:"state"`
+	Duration string      `json:"duration"`
+}
+
+// parseState interprets a single state value, accepting the configured state payloads as well as common boolean spellings
+func parseState(value string, payloads Payloads) (CoilState, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case payloads.On != "" && value == payloads.On:
+		return StateOn, nil
+	case payloads.Off != "" && value == payloads.Off:
+		return StateOff, nil
+	}
+	switch strings.ToUpper(value) {
+	case "ON", "TRUE", "1":
+		return StateOn, nil
+	case "OFF", "FALSE", "0":
+		return StateOff, nil
+	case "TOGGLE":
+		return StateToggle, nil
+	}
+	return StateOff, fmt.Errorf("unknown coil state %q", value)
+}
+
+// ParseCoilCommand interprets a command payload for a coil, either a plain state or a JSON object like {"state":"ON","duration":"500ms"}.
+// Payloads which can't be interpreted are rejected rather than switching the coil off.
+func ParseCoilCommand(payload []byte, payloads Payloads) (command CoilCommand, err error) {
+	payload = bytes.TrimSpace(payload)
+	if !bytes.HasPrefix(payload, []byte("{")) {
+		command.State, err = parseState(string(payload), payloads)
+		return
+	}
+	var raw jsonCoilCommand
+	if err = json.Unmarshal(payload, &raw); err != nil {
+		return command, fmt.Errorf("invalid coil command %q: %s", payload, err)
+	}
+	if raw.Duration != "" {
+		if command.Duration, err = time.ParseDuration(raw.Duration); err != nil || command.Duration < 0 {
+			return command, fmt.Errorf("invalid coil command %q: bad duration", payload)
+		}
+	}
+	switch state := raw.State.(type) {
+	case string:
+		command.State, err = parseState(state, payloads)
+	case bool:
+		if state {
+			command.State = StateOn
+		}
+	case float64:
+		command.State, err = parseState(fmt.Sprint(state), payloads)
+	default:
+		err = fmt.Errorf("invalid coil command %q: missing state", payload)
+	}
+	return
+}
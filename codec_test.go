@@ -0,0 +1,67 @@
+package modbridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCoilCommand(t *testing.T) {
+	cases := []struct {
+		payload  string
+		payloads Payloads
+		expected CoilState
+		valid    bool
+	}{
+		{payload: "ON", expected: StateOn, valid: true},
+		{payload: "on", expected: StateOn, valid: true},
+		{payload: "OFF", expected: StateOff, valid: true},
+		{payload: "true", expected: StateOn, valid: true},
+		{payload: "False", expected: StateOff, valid: true},
+		{payload: "1", expected: StateOn, valid: true},
+		{payload: " 0\n", expected: StateOff, valid: true},
+		{payload: "TOGGLE", expected: StateToggle, valid: true},
+		{payload: "open", payloads: Payloads{On: "open", Off: "closed"}, expected: StateOn, valid: true},
+		{payload: "closed", payloads: Payloads{On: "open", Off: "closed"}, expected: StateOff, valid: true},
+		{payload: `{"state":"ON"}`, expected: StateOn, valid: true},
+		{payload: `{"state": "toggle"}`, expected: StateToggle, valid: true},
+		{payload: `{"state":false}`, expected: StateOff, valid: true},
+		{payload: `{"state":1}`, expected: StateOn, valid: true},
+		{payload: "onn", valid: false},
+		{payload: "", valid: false},
+		{payload: "2", valid: false},
+		{payload: `{"state":"ON"`, valid: false},
+		{payload: `{"value":"ON"}`, valid: false},
+		{payload: `{"state":null}`, valid: false},
+	}
+	for _, testCase := range cases {
+		command, err := ParseCoilCommand([]byte(testCase.payload), testCase.payloads)
+		if (err == nil) != testCase.valid {
+			t.Errorf("Expected valid %v for %q, got %v\n", testCase.valid, testCase.payload, err)
+		}
+		if err == nil && command.State != testCase.expected {
+			t.Errorf("Expected state %v for %q, got %v\n", testCase.expected, testCase.payload, command.State)
+		}
+	}
+}
+
+func TestParseCoilCommandDuration(t *testing.T) {
+	cases := []struct {
+		payload  string
+		expected CoilCommand
+		valid    bool
+	}{
+		{payload: `{"state":"ON","duration":"500ms"}`, expected: CoilCommand{State: StateOn, Duration: 500 * time.Millisecond}, valid: true},
+		{payload: `{"state":"ON"}`, expected: CoilCommand{State: StateOn}, valid: true},
+		{payload: `{"state":"ON","duration":"soon"}`, valid: false},
+		{payload: `{"state":"ON","duration":"-1s"}`, valid: false},
+	}
+	for _, testCase := range cases {
+		command, err := ParseCoilCommand([]byte(testCase.payload), Payloads{})
+		if (err == nil) != testCase.valid {
+			t.Errorf("Expected valid %v for %q, got %v\n", testCase.valid, testCase.payload, err)
+		}
+		if err == nil && command != testCase.expected {
+			t.Errorf("Expected command %v for %q, got %v\n", testCase.expected, testCase.payload, command)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package modbridge
 
 import (
+	"fmt"
 	"log"
 	"time"
 
@@ -16,6 +17,70 @@ const (
 	NC                   // Normally Closed
 )
 
+// UnmarshalYAML reads in a switch type from its "NO" or "NC" representation
+func (switchType *SwitchType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	switch value {
+	case "", "NO":
+		*switchType = NO
+	case "NC":
+		*switchType = NC
+	default:
+		return fmt.Errorf("unknown switch type %q", value)
+	}
+	return nil
+}
+
+// PublishMode indicates what to publish for a coil when polling it
+type PublishMode string
+
+// Publish mode constants
+const (
+	Trigger  PublishMode = "trigger"  // Default, publish on the active edge only
+	Both     PublishMode = "both"     // Publish on both the active (press) and inactive (release) edge
+	State    PublishMode = "state"    // Publish the state on every change
+	Periodic PublishMode = "periodic" // Publish the state on every poll
+	Gesture  PublishMode = "gesture"  // Publish single, double and long presses, as well as hold events
+)
+
+// UnmarshalYAML rejects unknown publish modes when reading in the config
+func (publishMode *PublishMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	switch PublishMode(value) {
+	case "", Trigger, Both, State, Periodic, Gesture:
+		*publishMode = PublishMode(value)
+		return nil
+	}
+	return fmt.Errorf("unknown publish mode %q", value)
+}
+
+// Payloads holds the strings published for each of the events, where empty strings fall back to the defaults
+type Payloads struct {
+	Trigger string
+	Press   string
+	Release string
+	On      string
+	Off     string
+	Single  string
+	Double  string
+	Long    string
+	Hold    string
+}
+
+// orDefault returns the value if set, the fallback otherwise
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
 // CoilUpdater represents a coil to be polled
 type CoilUpdater interface {
 	Update()
@@ -28,6 +93,18 @@ type Coil struct {
 	previous   bool
 	current    bool
 	switchType SwitchType
+	publish    PublishMode
+	payloads   Payloads
+	qos        byte
+	retain     bool
+	topic      string
+	filler     bool
+	debounce   time.Duration
+	now        func() time.Time
+	candidate  bool
+	changedAt  time.Time
+	gestures   Gestures
+	gesture    gesture
 }
 
 // rising checks whether the value switched from false to true
@@ -40,13 +117,109 @@ func (coil *Coil) falling() bool {
 	return !coil.current && coil.previous
 }
 
+// pressed checks whether the coil switched to its active state, taking into account the switch type
+func (coil *Coil) pressed() bool {
+	return (coil.switchType == NO && coil.rising()) || (coil.switchType == NC && coil.falling())
+}
+
+// released checks whether the coil switched to its inactive state, taking into account the switch type
+func (coil *Coil) released() bool {
+	return (coil.switchType == NO && coil.falling()) || (coil.switchType == NC && coil.rising())
+}
+
+// active indicates whether the coil is currently in its active state, i.e. closed for NO and open for NC
+func (coil *Coil) active() bool {
+	return coil.invert(coil.current)
+}
+
+// invert converts between the raw value of the coil and whether it is on, which are opposite for normally closed coils
+func (coil *Coil) invert(value bool) bool {
+	return value != (coil.switchType == NC)
+}
+
+// state returns the payload representing the current state of the coil
+func (coil *Coil) state() string {
+	return coil.payload(coil.active())
+}
+
+// payload returns the state payload for a coil being on or off
+func (coil *Coil) payload(on bool) string {
+	if on {
+		return orDefault(coil.payloads.On, "ON")
+	}
+	return orDefault(coil.payloads.Off, "OFF")
+}
+
+// key identifies the coil in a snapshot by its state topic
+func (coil *Coil) key() string {
+	return orDefault(coil.topic, coil.Slug)
+}
+
+// publishPayload logs and publishes a payload on the state topic of the coil, which defaults to its slug
+func (coil *Coil) publishPayload(payload string, mqttClient mqtt.Client) {
+	log.Printf("%s  -  %s for %s", time.Now().Format(time.RFC3339), payload, coil.Slug)
+	mqttClient.Publish(coil.key(), coil.qos, coil.retain, payload)
+}
+
+// seed sets the initial state of the coil without detecting any edges, only publishing it for the state publish modes
+func (coil *Coil) seed(value bool, mqttClient mqtt.Client) {
+	coil.previous, coil.current = value, value
+	// A button held while the bridge starts isn't pressed, nor held long enough, from the point of view of the gestures
+	coil.gesture = gesture{stale: coil.active()}
+	if coil.publish == State || coil.publish == Periodic {
+		coil.publishPayload(coil.state(), mqttClient)
+	}
+}
+
+// clock returns the current time, from the clock injected for testing if any
+func (coil *Coil) clock() time.Time {
+	if coil.now != nil {
+		return coil.now()
+	}
+	return time.Now()
+}
+
+// debounced filters a new value, only accepting a change once it has been stable for the debounce duration
+func (coil *Coil) debounced(value bool) bool {
+	if coil.debounce == 0 {
+		return value
+	}
+	if value == coil.current {
+		coil.changedAt = time.Time{}
+		return value
+	}
+	now := coil.clock()
+	if coil.changedAt.IsZero() || coil.candidate != value {
+		coil.candidate, coil.changedAt = value, now
+	}
+	if now.Sub(coil.changedAt) < coil.debounce {
+		return coil.current
+	}
+	coil.changedAt = time.Time{}
+	return value
+}
+
 // Update handles checking a new value against the current and previous retained state we have for a coil
 func (coil *Coil) Update(value bool, mqttClient mqtt.Client) {
-	coil.previous, coil.current = coil.current, value
-	if coil.current != coil.previous {
-		if (coil.switchType == NO && coil.rising()) || (coil.switchType == NC && coil.falling()) {
-			log.Printf("%s  -  trigger for %s", time.Now().Format(time.RFC3339), coil.Slug)
-			mqttClient.Publish(coil.Slug, 0, false, "trigger")
+	coil.previous, coil.current = coil.current, coil.debounced(value)
+	switch coil.publish {
+	case Periodic:
+		coil.publishPayload(coil.state(), mqttClient)
+	case State:
+		if coil.current != coil.previous {
+			coil.publishPayload(coil.state(), mqttClient)
+		}
+	case Gesture:
+		coil.updateGesture(mqttClient)
+	case Both:
+		if coil.pressed() {
+			coil.publishPayload(orDefault(coil.payloads.Press, "press"), mqttClient)
+		} else if coil.released() {
+			coil.publishPayload(orDefault(coil.payloads.Release, "release"), mqttClient)
+		}
+	default:
+		if coil.pressed() {
+			coil.publishPayload(orDefault(coil.payloads.Trigger, "trigger"), mqttClient)
 		}
 	}
 }
@@ -2,6 +2,7 @@ package modbridge
 
 import (
 	"testing"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/mhemeryck/modbridge/mocks"
@@ -127,3 +128,82 @@ func TestCoilUpdate(t *testing.T) {
 		}
 	}
 }
+
+func TestCoilUpdatePublishMode(t *testing.T) {
+	table := []struct {
+		publish         PublishMode
+		payloads        Payloads
+		switchType      SwitchType
+		initialCurrent  bool
+		value           bool
+		expectedPayload string
+	}{
+		// Both: press and release
+		{publish: Both, initialCurrent: false, value: true, expectedPayload: "press"},
+		{publish: Both, initialCurrent: true, value: false, expectedPayload: "release"},
+		{publish: Both, switchType: NC, initialCurrent: true, value: false, expectedPayload: "press"},
+		{publish: Both, initialCurrent: true, value: true, expectedPayload: ""},
+		// State: only on change
+		{publish: State, initialCurrent: false, value: true, expectedPayload: "ON"},
+		{publish: State, initialCurrent: true, value: false, expectedPayload: "OFF"},
+		{publish: State, switchType: NC, initialCurrent: true, value: false, expectedPayload: "ON"},
+		{publish: State, initialCurrent: false, value: false, expectedPayload: ""},
+		// Periodic: on every poll
+		{publish: Periodic, initialCurrent: false, value: false, expectedPayload: "OFF"},
+		{publish: Periodic, initialCurrent: true, value: true, expectedPayload: "ON"},
+		// Custom payloads
+		{publish: Trigger, payloads: Payloads{Trigger: "pushed"}, initialCurrent: false, value: true, expectedPayload: "pushed"},
+		{publish: State, payloads: Payloads{On: "open", Off: "closed"}, initialCurrent: true, value: false, expectedPayload: "closed"},
+	}
+	for _, testCase := range table {
+		coil := Coil{Slug: "test", previous: testCase.initialCurrent, current: testCase.initialCurrent, switchType: testCase.switchType, publish: testCase.publish, payloads: testCase.payloads}
+		mqttClient := &mocks.MQTTClient{}
+		if testCase.expectedPayload != "" {
+			mqttClient.On("Publish", "test", byte(0), false, testCase.expectedPayload).Return(&mqtt.PublishToken{})
+		}
+		coil.Update(testCase.value, mqttClient)
+		mqttClient.AssertExpectations(t)
+		if testCase.expectedPayload == "" {
+			mqttClient.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		}
+	}
+}
+
+func TestCoilUpdateQoSRetain(t *testing.T) {
+	coil := Coil{Slug: "alarm", publish: State, qos: 2, retain: true}
+	mqttClient := &mocks.MQTTClient{}
+	mqttClient.On("Publish", "alarm", byte(2), true, "ON").Return(&mqtt.PublishToken{})
+	coil.Update(true, mqttClient)
+	mqttClient.AssertExpectations(t)
+}
+
+func TestCoilUpdateDebounce(t *testing.T) {
+	// Polls every 10ms with the value read and the expected debounced value
+	cases := []struct {
+		value    bool
+		expected bool
+	}{
+		{value: true, expected: false},  // bounce starts at 0ms
+		{value: false, expected: false}, // back off at 10ms
+		{value: true, expected: false},  // settles from 20ms on
+		{value: true, expected: false},
+		{value: true, expected: false},
+		{value: true, expected: true}, // stable for 30ms at 50ms
+		{value: false, expected: true},
+		{value: true, expected: true},
+	}
+	start := time.Now()
+	elapsed := time.Duration(0)
+	MQTTClient := &mocks.MQTTClient{}
+	MQTTClient.On("Publish", "button", byte(0), false, "trigger").Return(&mqtt.PublishToken{})
+	coil := Coil{Slug: "button", debounce: 30 * time.Millisecond, now: func() time.Time { return start.Add(elapsed) }}
+	for k, testCase := range cases {
+		elapsed = time.Duration(k) * 10 * time.Millisecond
+		coil.Update(testCase.value, MQTTClient)
+		if coil.current != testCase.expected {
+			t.Errorf("Expected %v at %s, got %v\n", testCase.expected, elapsed, coil.current)
+		}
+	}
+	// A single trigger for the whole bouncing press
+	MQTTClient.AssertNumberOfCalls(t, "Publish", 1)
+}
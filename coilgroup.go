@@ -1,40 +1,90 @@
 package modbridge
 
 import (
+	"fmt"
 	"sort"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/goburrow/modbus"
 )
 
-// CoilGroupUpdater exposes the required interfaces for polling CoilGroups
-type CoilGroupUpdater interface {
+// GroupUpdater exposes the required interfaces for polling coil and register groups
+type GroupUpdater interface {
 	Update() (err error)
 }
 
-// CoilGroup represents an array of coils which have contiguous Addresses
+// CoilGroup represents an array of coils or discrete inputs which have contiguous Addresses
 type CoilGroup struct {
 	offset       uint16
 	coils        []Coil
+	table        Table
+	seeded       bool
+	snapshot     *Snapshot
 	ModbusClient modbus.Client
 	MQTTClient   mqtt.Client
 }
 
-// Update call the modbus group range and update the corresponding coils
+// read calls the modbus function matching the table of the group
+func (coilGroup *CoilGroup) read(quantity uint16) ([]byte, error) {
+	if coilGroup.table == DiscreteInputTable {
+		return coilGroup.ModbusClient.ReadDiscreteInputs(coilGroup.offset, quantity)
+	}
+	return coilGroup.ModbusClient.ReadCoils(coilGroup.offset, quantity)
+}
+
+// Update call the modbus group range and update the corresponding coils.
+// The first update only seeds the state of the coils, unless they have a value in the snapshot to compare against.
 func (coilGroup *CoilGroup) Update() (err error) {
-	results, err := coilGroup.ModbusClient.ReadCoils(coilGroup.offset, uint16(len(coilGroup.coils)))
+	results, err := coilGroup.read(uint16(len(coilGroup.coils)))
 	if err != nil {
 		return
 	}
 	for k := range coilGroup.coils {
+		coil := &coilGroup.coils[k]
+		// Skip the unmapped addresses read across
+		if coil.filler {
+			continue
+		}
 		numberIndex := k / 8
 		bitOffset := uint16(k % 8)
-		value := results[numberIndex] & (1 << bitOffset)
-		coilGroup.coils[k].Update(value != 0, coilGroup.MQTTClient)
+		value := results[numberIndex]&(1<<bitOffset) != 0
+		if coilGroup.seeded {
+			coil.Update(value, coilGroup.MQTTClient)
+		} else {
+			coilGroup.seed(coil, value)
+		}
+		if coilGroup.snapshot != nil {
+			coilGroup.snapshot.set(coil.key(), value)
+		}
 	}
+	coilGroup.seeded = true
 	return
 }
 
+// seed sets the initial state of a coil, handling any change against the snapshot as if it happened while polling
+func (coilGroup *CoilGroup) seed(coil *Coil, value bool) {
+	if coilGroup.snapshot != nil {
+		if previous, ok := coilGroup.snapshot.get(coil.key()); ok {
+			coil.current = previous
+			coil.Update(value, coilGroup.MQTTClient)
+			return
+		}
+	}
+	coil.seed(value, coilGroup.MQTTClient)
+}
+
+// String describes the block of addresses read for the group
+func (coilGroup *CoilGroup) String() string {
+	unused := 0
+	for k := range coilGroup.coils {
+		if coilGroup.coils[k].filler {
+			unused++
+		}
+	}
+	last := int(coilGroup.offset) + len(coilGroup.coils) - 1
+	return fmt.Sprintf("%s %d-%d (%d unused)", coilGroup.table, coilGroup.offset, last, unused)
+}
+
 // ByAddress implements sorter interface, for sorting an array of coils based on Address
 type ByAddress []Coil
 
@@ -42,30 +92,44 @@ func (coils ByAddress) Len() int           { return len(coils) }
 func (coils ByAddress) Swap(i, j int)      { coils[i], coils[j] = coils[j], coils[i] }
 func (coils ByAddress) Less(i, j int) bool { return coils[i].Address < coils[j].Address }
 
+// MaxReadCoils is the maximum number of coils or discrete inputs the modbus protocol allows to read at once
+const MaxReadCoils = 2000
+
 // GroupCoils groups an array of coils into an array coil groups
 func GroupCoils(coils []Coil) []CoilGroup {
-	// Sort inputs by Address first
-	sort.Sort(ByAddress(coils))
+	return groupCoils(coils, CoilTable, MaxReadCoils, 0)
+}
 
-	// Single-length case
-	if len(coils) == 1 {
-		return []CoilGroup{{offset: coils[0].Address, coils: coils}}
-	}
+// GroupDiscreteInputs groups an array of discrete inputs into an array of coil groups reading from the discrete inputs table
+func GroupDiscreteInputs(inputs []Coil) []CoilGroup {
+	return groupCoils(inputs, DiscreteInputTable, MaxReadCoils, 0)
+}
 
-	// Start with the first group by considering it the 1-length case
-	groups := GroupCoils(coils[:1])
+// groupCoils groups an array of coils from one table into groups holding at most maxSize coils.
+// Holes of up to maxGap unmapped addresses are read across, filling them up with coils which are skipped on updates.
+func groupCoils(coils []Coil, table Table, maxSize int, maxGap int) (groups []CoilGroup) {
+	// Sort inputs by Address first
+	sort.Sort(ByAddress(coils))
 
 	// Loop over all in the input and either add them to the existing group, or add a new one
-	for _, coil := range coils[1:] {
+	for _, coil := range coils {
 		groupIndex := len(groups) - 1
-		// Compare the curent input Address against the offset + length of the current group
-		if coil.Address == groups[groupIndex].offset+uint16(len(groups[groupIndex].coils)) {
-			// Add the current input to the current group
+		// Compare the current input Address against the offset + length of the current group, without overflowing
+		gap := -1
+		if groupIndex >= 0 {
+			gap = int(coil.Address) - int(groups[groupIndex].offset) - len(groups[groupIndex].coils)
+		}
+		if gap >= 0 && gap <= maxGap && len(groups[groupIndex].coils)+gap < maxSize {
+			// Fill up the gap and add the current input to the current group
+			for k := 0; k < gap; k++ {
+				filler := Coil{Address: coil.Address - uint16(gap-k), filler: true}
+				groups[groupIndex].coils = append(groups[groupIndex].coils, filler)
+			}
 			groups[groupIndex].coils = append(groups[groupIndex].coils, coil)
 		} else {
 			// Start a new group
-			groups = append(groups, CoilGroup{offset: coil.Address, coils: []Coil{coil}})
+			groups = append(groups, CoilGroup{offset: coil.Address, coils: []Coil{coil}, table: table})
 		}
 	}
-	return groups
+	return
 }
@@ -107,3 +107,137 @@ func TestGroupCoils(t *testing.T) {
 		t.Errorf("Error grouping coils: expected %v, got %v\n", expected, actual)
 	}
 }
+
+func TestCoilGroupUpdateDiscreteInputs(t *testing.T) {
+	coils := []Coil{{Address: 3, Slug: "test", switchType: NO}}
+	ModbusClient := &mocks.ModbusClient{}
+	MQTTClient := &mocks.MQTTClient{}
+	coilGroup := &CoilGroup{offset: 3, coils: coils, table: DiscreteInputTable, seeded: true, ModbusClient: ModbusClient, MQTTClient: MQTTClient}
+	ModbusClient.On("ReadDiscreteInputs", uint16(3), uint16(1)).Return([]byte{1}, nil)
+	MQTTClient.On("Publish", "test", byte(0), false, "trigger").Return(&mqtt.PublishToken{})
+
+	if err := coilGroup.Update(); err != nil {
+		t.Errorf("Expected no error but got %v\n", err)
+	}
+	ModbusClient.AssertExpectations(t)
+	MQTTClient.AssertExpectations(t)
+	if !coilGroup.coils[0].current {
+		t.Errorf("Expected discrete input to be updated to true\n")
+	}
+}
+
+func TestGroupDiscreteInputs(t *testing.T) {
+	input := []Coil{{Address: 1}, {Address: 0}}
+	expected := []CoilGroup{
+		{offset: 0, coils: []Coil{{Address: 0}, {Address: 1}}, table: DiscreteInputTable},
+	}
+	actual := GroupDiscreteInputs(input)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Error grouping discrete inputs: expected %v, got %v\n", expected, actual)
+	}
+	if groups := GroupCoils(nil); len(groups) != 0 {
+		t.Errorf("Expected no groups for empty input, got %v\n", groups)
+	}
+}
+
+func TestGroupCoilsLimits(t *testing.T) {
+	var input []Coil
+	for address := 0; address < 2*MaxReadCoils+5; address++ {
+		input = append(input, Coil{Address: uint16(address)})
+	}
+	groups := GroupCoils(input)
+	if len(groups) != 3 || len(groups[0].coils) != MaxReadCoils || groups[1].offset != MaxReadCoils || len(groups[2].coils) != 5 {
+		t.Errorf("Expected groups to be split at %d coils, got %d groups\n", MaxReadCoils, len(groups))
+	}
+
+	// The end of the address space doesn't wrap around to address 0
+	groups = groupCoils([]Coil{{Address: 0}, {Address: 65534}, {Address: 65535}}, CoilTable, 2, 0)
+	if len(groups) != 2 || groups[1].offset != 65534 || len(groups[1].coils) != 2 {
+		t.Errorf("Expected groups at 0 and 65534, got %v\n", groups)
+	}
+	groups = groupCoils([]Coil{{Address: 0}, {Address: 1}, {Address: 2}}, DiscreteInputTable, 2, 0)
+	if len(groups) != 2 || groups[1].offset != 2 || groups[1].table != DiscreteInputTable {
+		t.Errorf("Expected groups split at the maximum size, got %v\n", groups)
+	}
+}
+
+func TestGroupCoilsGaps(t *testing.T) {
+	input := []Coil{{Address: 0}, {Address: 1}, {Address: 3}, {Address: 4}, {Address: 8}}
+	expected := []CoilGroup{
+		{offset: 0, coils: []Coil{{Address: 0}, {Address: 1}, {Address: 2, filler: true}, {Address: 3}, {Address: 4}}},
+		{offset: 8, coils: []Coil{{Address: 8}}},
+	}
+	actual := groupCoils(input, CoilTable, MaxReadCoils, 2)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Error grouping coils across gaps: expected %v, got %v\n", expected, actual)
+	}
+	if description := actual[0].String(); description != "coils 0-4 (1 unused)" {
+		t.Errorf("Expected description of the read block, got %s\n", description)
+	}
+	// The gap counts towards the maximum read size
+	if groups := groupCoils([]Coil{{Address: 0}, {Address: 2}}, CoilTable, 2, 2); len(groups) != 2 {
+		t.Errorf("Expected the gap not to be bridged beyond the maximum size, got %v\n", groups)
+	}
+}
+
+func TestCoilGroupUpdateGaps(t *testing.T) {
+	ModbusClient := &mocks.ModbusClient{}
+	MQTTClient := &mocks.MQTTClient{}
+	coilGroup := &CoilGroup{
+		offset:       0,
+		coils:        groupCoils([]Coil{{Address: 0, Slug: "first"}, {Address: 2, Slug: "second"}}, CoilTable, MaxReadCoils, 1)[0].coils,
+		seeded:       true,
+		ModbusClient: ModbusClient,
+		MQTTClient:   MQTTClient,
+	}
+	// The unmapped coil at 1 is set, but nothing gets published for it
+	ModbusClient.On("ReadCoils", uint16(0), uint16(3)).Return([]byte{6}, nil)
+	MQTTClient.On("Publish", "second", byte(0), false, "trigger").Return(&mqtt.PublishToken{})
+
+	if err := coilGroup.Update(); err != nil {
+		t.Errorf("Expected no error but got %v\n", err)
+	}
+	ModbusClient.AssertExpectations(t)
+	MQTTClient.AssertExpectations(t)
+}
+
+func TestCoilGroupUpdateSeed(t *testing.T) {
+	ModbusClient := &mocks.ModbusClient{}
+	MQTTClient := &mocks.MQTTClient{}
+	coils := []Coil{{Address: 0, Slug: "button"}, {Address: 1, Slug: "door", publish: State}}
+	coilGroup := &CoilGroup{offset: 0, coils: coils, ModbusClient: ModbusClient, MQTTClient: MQTTClient}
+	ModbusClient.On("ReadCoils", uint16(0), uint16(2)).Return([]byte{3}, nil)
+	MQTTClient.On("Publish", "door", byte(0), false, "ON").Return(&mqtt.PublishToken{})
+
+	// The button being on at startup doesn't trigger, the state of the door is published
+	if err := coilGroup.Update(); err != nil {
+		t.Errorf("Expected no error but got %v\n", err)
+	}
+	MQTTClient.AssertExpectations(t)
+	MQTTClient.AssertNotCalled(t, "Publish", "button", byte(0), false, "trigger")
+	if !coilGroup.seeded || !coilGroup.coils[0].previous || !coilGroup.coils[0].current {
+		t.Errorf("Expected the coils to be seeded, got %v\n", coilGroup.coils)
+	}
+}
+
+func TestCoilGroupUpdateSnapshot(t *testing.T) {
+	ModbusClient := &mocks.ModbusClient{}
+	MQTTClient := &mocks.MQTTClient{}
+	snapshot := &Snapshot{values: map[string]bool{"button": false, "door": true}}
+	coils := []Coil{{Address: 0, Slug: "button"}, {Address: 1, Slug: "door", publish: State}, {Address: 2, Slug: "new"}}
+	coilGroup := &CoilGroup{offset: 0, coils: coils, snapshot: snapshot, ModbusClient: ModbusClient, MQTTClient: MQTTClient}
+	ModbusClient.On("ReadCoils", uint16(0), uint16(3)).Return([]byte{5}, nil)
+	MQTTClient.On("Publish", "button", byte(0), false, "trigger").Return(&mqtt.PublishToken{})
+	MQTTClient.On("Publish", "door", byte(0), false, "OFF").Return(&mqtt.PublishToken{})
+
+	// Changes against the snapshot are published, coils missing from it are only seeded
+	if err := coilGroup.Update(); err != nil {
+		t.Errorf("Expected no error but got %v\n", err)
+	}
+	MQTTClient.AssertExpectations(t)
+	MQTTClient.AssertNumberOfCalls(t, "Publish", 2)
+	expected := map[string]bool{"button": true, "door": false, "new": true}
+	if !reflect.DeepEqual(snapshot.values, expected) || !snapshot.dirty {
+		t.Errorf("Expected snapshot %v, got %v\n", expected, snapshot.values)
+	}
+}
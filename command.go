@@ -0,0 +1,96 @@
+package modbridge
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReadOnly is returned when writing to a point which is configured as read-only
+var ErrReadOnly = errors.New("point is read-only")
+
+// ErrWriteOnly is returned when toggling a coil which is configured as write-only, as that takes reading it
+var ErrWriteOnly = errors.New("point is write-only")
+
+// command holds what is needed to handle the writes received on the command topic of a coil or holding register
+type command struct {
+	mode       ModbusMode
+	errorTopic string
+	confirm    bool
+	autoOff    time.Duration
+	coil       *Coil
+	register   *Register
+
+	// Writes to the coil are serialized, as they may also come from the timer of a pulse
+	mu    sync.Mutex
+	pulse *pulse
+}
+
+// pulse is a pending switch off of a coil, after it was switched on for a limited time.
+// A failed switch off is retried with its own backoff, as the timer fires outside of the polling loop.
+type pulse struct {
+	timer   *time.Timer
+	backoff *Backoff
+}
+
+// writable indicates whether the mode of the point allows writing to it
+func (command *command) writable() bool {
+	return command.mode != Read
+}
+
+// readable indicates whether the mode of the point allows reading it, like for toggling or confirming a write
+func (command *command) readable() bool {
+	return command.mode != Write
+}
+
+// allowCoilCommand rejects the coil commands which can't be carried out without reading the coil
+func (command *command) allowCoilCommand(coilCommand CoilCommand) error {
+	if coilCommand.State == StateToggle && !command.readable() {
+		return ErrWriteOnly
+	}
+	return nil
+}
+
+// slug returns the slug of the point
+func (command *command) slug() string {
+	if command.coil != nil {
+		return command.coil.Slug
+	}
+	return command.register.Slug
+}
+
+// qos returns the quality of service level of the point, used for publishing errors
+func (command *command) qos() byte {
+	if command.coil != nil {
+		return command.coil.qos
+	}
+	return command.register.qos
+}
+
+// commands generates a mapping of the command topics to the coils and holding registers, whatever their mode.
+// Write-only points are never read back, even with confirm set.
+func (c *Configuration) commands() map[string]*command {
+	commands := make(map[string]*command)
+	for _, coilConfig := range c.Coils {
+		topics := coilConfig.TopicConfig.resolve(c.TopicConfig)
+		coil := coilConfig.coil(c)
+		commands[c.topic(topics.CommandTopic, coilConfig.Slug)] = &command{
+			mode:       coilConfig.Mode,
+			errorTopic: c.topic(topics.ErrorTopic, coilConfig.Slug),
+			confirm:    c.confirm(coilConfig.Confirm) && coilConfig.Mode != Write,
+			autoOff:    coilConfig.AutoOff,
+			coil:       &coil,
+		}
+	}
+	for _, registerConfig := range c.HoldingRegisters {
+		topics := registerConfig.TopicConfig.resolve(c.TopicConfig)
+		register := registerConfig.register(c)
+		commands[c.topic(topics.CommandTopic, registerConfig.Slug)] = &command{
+			mode:       registerConfig.Mode,
+			errorTopic: c.topic(topics.ErrorTopic, registerConfig.Slug),
+			confirm:    c.confirm(registerConfig.Confirm) && registerConfig.Mode != Write,
+			register:   &register,
+		}
+	}
+	return commands
+}
@@ -0,0 +1,48 @@
+package modbridge
+
+import "testing"
+
+func TestCommands(t *testing.T) {
+	no := false
+	c := Configuration{
+		TopicPrefix: "modbridge",
+		Confirm:     true,
+		Coils: []CoilConfig{
+			{Address: 0, Mode: Read, Slug: "button"},
+			{Address: 1, Mode: ReadWrite, Slug: "relay", Confirm: &no},
+		},
+		HoldingRegisters: []RegisterConfig{{Address: 2, Mode: Write, Slug: "setpoint", TopicConfig: TopicConfig{ErrorTopic: "{slug}/failed"}}},
+	}
+	commands := c.commands()
+	if len(commands) != 3 {
+		t.Fatalf("Expected 3 commands, got %v\n", commands)
+	}
+	button, relay, setpoint := commands["modbridge/button/set"], commands["modbridge/relay/set"], commands["modbridge/setpoint/set"]
+	if button == nil || button.writable() || button.coil.Address != 0 || !button.confirm {
+		t.Errorf("Expected a read-only command for the button, got %v\n", button)
+	}
+	if relay == nil || !relay.writable() || relay.errorTopic != "modbridge/relay/error" || relay.confirm {
+		t.Errorf("Expected a writable command for the relay without confirmation, got %v\n", relay)
+	}
+	if setpoint == nil || !setpoint.writable() || setpoint.register.Address != 2 || setpoint.errorTopic != "setpoint/failed" || setpoint.confirm {
+		t.Errorf("Expected a writable command for the write-only setpoint without confirmation, got %v\n", setpoint)
+	}
+}
+
+func TestCommandAllowCoilCommand(t *testing.T) {
+	cases := []struct {
+		mode     ModbusMode
+		state    CoilState
+		expected error
+	}{
+		{mode: ReadWrite, state: StateToggle, expected: nil},
+		{mode: Write, state: StateOn, expected: nil},
+		{mode: Write, state: StateToggle, expected: ErrWriteOnly},
+	}
+	for _, testCase := range cases {
+		command := &command{mode: testCase.mode}
+		if err := command.allowCoilCommand(CoilCommand{State: testCase.state}); err != testCase.expected {
+			t.Errorf("Expected %v for %v in mode %s, got %v\n", testCase.expected, testCase.state, testCase.mode, err)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package modbridge
 
+import (
+	"fmt"
+	"time"
+)
+
 // ModbusMode indicates whether the involved register or coil is read-only or read and write allowed
 type ModbusMode string
 
@@ -12,9 +17,39 @@ const (
 
 // CoilConfig holds the description of the coil part of a device modbus map
 type CoilConfig struct {
-	Address uint16
-	Mode    ModbusMode
-	Slug    string
+	PublishConfig `yaml:",inline"`
+	TopicConfig   `yaml:",inline"`
+
+	Address    uint16
+	Mode       ModbusMode
+	Slug       string
+	SwitchType SwitchType `yaml:"switch_type"`
+	Publish    PublishMode
+	Payloads   Payloads
+
+	PollInterval time.Duration `yaml:"poll_interval"`
+	Confirm      *bool
+	Debounce     time.Duration
+	Gestures     Gestures
+	AutoOff      time.Duration `yaml:"auto_off"`
+}
+
+// coil generates the coil described by a CoilConfig, using the configuration defaults where not set
+func (coilConfig *CoilConfig) coil(c *Configuration) Coil {
+	qos, retain := coilConfig.PublishConfig.resolve(c.PublishConfig)
+	topics := coilConfig.TopicConfig.resolve(c.TopicConfig)
+	return Coil{
+		Address:    coilConfig.Address,
+		Slug:       coilConfig.Slug,
+		switchType: coilConfig.SwitchType,
+		publish:    coilConfig.Publish,
+		payloads:   coilConfig.Payloads,
+		qos:        qos,
+		retain:     retain,
+		topic:      c.topic(topics.StateTopic, coilConfig.Slug),
+		debounce:   coilConfig.Debounce,
+		gestures:   coilConfig.Gestures,
+	}
 }
 
 // isWriteOnly indicates whether a given CoilConfig is write-only
@@ -22,12 +57,187 @@ func (coilConfig *CoilConfig) isWriteOnly() bool {
 	return coilConfig.Mode == Write
 }
 
+// RegisterConfig holds the description of the register part of a device modbus map
+type RegisterConfig struct {
+	PublishConfig `yaml:",inline"`
+	TopicConfig   `yaml:",inline"`
+
+	Address   uint16
+	Mode      ModbusMode
+	Slug      string
+	Type      DataType
+	WordOrder Order `yaml:"word_order"`
+	ByteOrder Order `yaml:"byte_order"`
+	Scale     float64
+	Offset    float64
+	Precision *int
+	Unit      string
+
+	PollInterval time.Duration `yaml:"poll_interval"`
+	Confirm      *bool
+}
+
+// isWriteOnly indicates whether a given RegisterConfig is write-only
+func (registerConfig *RegisterConfig) isWriteOnly() bool {
+	return registerConfig.Mode == Write
+}
+
 // Configuration of modbridge
 type Configuration struct {
-	Coils           []CoilConfig
-	MQTTBrokerURI   string `yaml:"mqtt_broker_uri"`
-	MQTTClientID    string `yaml:"mqtt_client_id"`
-	ModbusServerURI string `yaml:"modbus_server_uri"`
+	PublishConfig `yaml:",inline"`
+	TopicConfig   `yaml:",inline"`
+
+	Coils            []CoilConfig
+	DiscreteInputs   []CoilConfig     `yaml:"discrete_inputs"`
+	HoldingRegisters []RegisterConfig `yaml:"holding_registers"`
+	InputRegisters   []RegisterConfig `yaml:"input_registers"`
+	MQTTBrokerURI    string           `yaml:"mqtt_broker_uri"`
+	MQTTClientID     string           `yaml:"mqtt_client_id"`
+	ModbusServerURI  string           `yaml:"modbus_server_uri"`
+	UnitID           byte             `yaml:"unit_id"`
+	Timeout          time.Duration    `yaml:"timeout"`
+	TopicPrefix      string           `yaml:"topic_prefix"`
+	Device           string
+	Devices          []DeviceConfig
+
+	// Polling interval of the points without one, polled in turn when not set
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Maximum number of coils or registers read at once, bounded by the protocol limits
+	MaxReadSize int `yaml:"max_read_size"`
+	// Maximum number of unmapped addresses to read across, rather than splitting the read
+	MaxGap int `yaml:"max_gap"`
+	// Read back the value after each write, publishing it on the state topic
+	Confirm bool
+	// File keeping the last known coil values, to publish changes which happened while the bridge was down
+	SnapshotFile string `yaml:"snapshot_file"`
+	// Topic template for writing many points at once, with a JSON object of the slugs and their payloads
+	BatchTopic string `yaml:"batch_topic"`
+
+	// Home Assistant integration
+	AvailabilityTopic       string `yaml:"availability_topic"`
+	DeviceAvailabilityTopic string `yaml:"device_availability_topic"`
+	Discovery               DiscoveryConfig
+}
+
+// DeviceConfig holds the description of a single modbus device in a bridge with multiple devices
+type DeviceConfig struct {
+	Name             string
+	ModbusServerURI  string        `yaml:"modbus_server_uri"`
+	UnitID           byte          `yaml:"unit_id"`
+	Timeout          time.Duration `yaml:"timeout"`
+	Coils            []CoilConfig
+	DiscreteInputs   []CoilConfig     `yaml:"discrete_inputs"`
+	HoldingRegisters []RegisterConfig `yaml:"holding_registers"`
+	InputRegisters   []RegisterConfig `yaml:"input_registers"`
+
+	PollInterval time.Duration `yaml:"poll_interval"`
+	MaxReadSize  int           `yaml:"max_read_size"`
+	MaxGap       int           `yaml:"max_gap"`
+}
+
+// hasPoints indicates whether any points are configured at the top level
+func (c *Configuration) hasPoints() bool {
+	return len(c.Coils)+len(c.DiscreteInputs)+len(c.HoldingRegisters)+len(c.InputRegisters) > 0
+}
+
+// DevicesList generates a configuration for each of the devices, which inherit all of the global settings.
+// The points at the top level form a device on their own, which is the only one in case no devices are listed.
+func (c *Configuration) DevicesList() (devices []Configuration) {
+	if len(c.Devices) == 0 || c.hasPoints() {
+		device := *c
+		device.Devices = nil
+		devices = append(devices, device)
+	}
+	for k, deviceConfig := range c.Devices {
+		device := *c
+		device.Devices = nil
+		device.Device = orDefault(deviceConfig.Name, fmt.Sprintf("device-%d", k+1))
+		device.ModbusServerURI = deviceConfig.ModbusServerURI
+		device.UnitID = deviceConfig.UnitID
+		device.Timeout = deviceConfig.Timeout
+		device.Coils = deviceConfig.Coils
+		device.DiscreteInputs = deviceConfig.DiscreteInputs
+		device.HoldingRegisters = deviceConfig.HoldingRegisters
+		device.InputRegisters = deviceConfig.InputRegisters
+		if deviceConfig.PollInterval != 0 {
+			device.PollInterval = deviceConfig.PollInterval
+		}
+		if deviceConfig.MaxReadSize != 0 {
+			device.MaxReadSize = deviceConfig.MaxReadSize
+		}
+		if deviceConfig.MaxGap != 0 {
+			device.MaxGap = deviceConfig.MaxGap
+		}
+		// Each device announces itself as a separate node
+		device.Discovery.NodeID = ""
+		devices = append(devices, device)
+	}
+	return
+}
+
+// Validate checks the configuration of all devices for points which can't be read, or topics they can't share
+func (c *Configuration) Validate() error {
+	// Only a single handler can be subscribed to a topic, so each device needs its own command topics
+	owners := make(map[string]string)
+	for _, device := range c.DevicesList() {
+		for _, topic := range device.deviceTopics() {
+			if other, ok := owners[topic]; ok {
+				return fmt.Errorf("topic %s shared by devices %s and %s, missing {device} in its template", topic, other, device.Device)
+			}
+			owners[topic] = device.Device
+		}
+		if device.MaxReadSize < 0 {
+			return fmt.Errorf("invalid max_read_size %d for device %s", device.MaxReadSize, device.Device)
+		}
+		if device.MaxGap < 0 {
+			return fmt.Errorf("invalid max_gap %d for device %s", device.MaxGap, device.Device)
+		}
+		for _, registerConfig := range append(device.HoldingRegisters, device.InputRegisters...) {
+			size := int(registerConfig.Type.Size())
+			if int(registerConfig.Address)+size > 1<<16 {
+				return fmt.Errorf("register %s at address %d overflows the address space", registerConfig.Slug, registerConfig.Address)
+			}
+			if size > device.maxReadSize(MaxReadRegisters) {
+				return fmt.Errorf("register %s of type %s doesn't fit in a read of %d registers", registerConfig.Slug, registerConfig.Type, device.MaxReadSize)
+			}
+		}
+	}
+	return nil
+}
+
+// deviceTopics lists the topics which belong to the device alone: its command topics, batch topic and availability topic
+func (c *Configuration) deviceTopics() (topics []string) {
+	for topic := range c.commands() {
+		topics = append(topics, topic)
+	}
+	if batch := c.batch(); batch != nil {
+		topics = append(topics, batch.topic)
+	}
+	if topic := c.deviceAvailabilityTopic(); topic != "" {
+		topics = append(topics, topic)
+	}
+	return
+}
+
+// maxReadSize returns the maximum number of coils or registers to read at once, within a protocol limit
+func (c *Configuration) maxReadSize(limit int) int {
+	if c.MaxReadSize > 0 && c.MaxReadSize < limit {
+		return c.MaxReadSize
+	}
+	return limit
+}
+
+// confirm indicates whether writes to a point are read back, falling back to the global setting
+func (c *Configuration) confirm(point *bool) bool {
+	if point != nil {
+		return *point
+	}
+	return c.Confirm
+}
+
+// topic renders a topic template for a point with the given slug
+func (c *Configuration) topic(template string, slug string) string {
+	return renderTopic(template, c.TopicPrefix, c.Device, slug)
 }
 
 // filterCoilConfigs applies a filter based on a test function passed in
@@ -43,7 +253,7 @@ func (c *Configuration) filterCoilConfig() (filtered []CoilConfig) {
 // CoilsList generates a list of non-write only coils from a configuration object
 func (c *Configuration) CoilsList() (coils []Coil) {
 	for _, coilConfig := range c.filterCoilConfig() {
-		coils = append(coils, Coil{Address: coilConfig.Address, Slug: coilConfig.Slug, switchType: NO})
+		coils = append(coils, coilConfig.coil(c))
 	}
 	return
 }
@@ -52,12 +262,81 @@ func (c *Configuration) CoilsList() (coils []Coil) {
 func (c *Configuration) CoilsMap() (coils map[string]Coil) {
 	coils = make(map[string]Coil)
 	for _, coilConfig := range c.Coils {
-		coils[coilConfig.Slug] = Coil{Address: coilConfig.Address, Slug: coilConfig.Slug, switchType: NO}
+		coils[coilConfig.Slug] = coilConfig.coil(c)
+	}
+	return
+}
+
+// DiscreteInputsList generates a list of discrete inputs from a configuration object
+func (c *Configuration) DiscreteInputsList() (inputs []Coil) {
+	for _, inputConfig := range c.DiscreteInputs {
+		inputs = append(inputs, inputConfig.coil(c))
 	}
 	return
 }
 
-// CoilGroupsList generates a list of groups, out of the filtered list obtained from the config
+// CoilGroupsList generates a list of groups, out of the filtered list of coils and the discrete inputs obtained from the config
 func (c *Configuration) CoilGroupsList() []CoilGroup {
-	return GroupCoils(c.CoilsList())
+	maxSize := c.maxReadSize(MaxReadCoils)
+	return append(
+		groupCoils(c.CoilsList(), CoilTable, maxSize, c.MaxGap),
+		groupCoils(c.DiscreteInputsList(), DiscreteInputTable, maxSize, c.MaxGap)...,
+	)
+}
+
+// filterRegisterConfig drops the write-only registers from a list of register configs
+func filterRegisterConfig(registerConfigs []RegisterConfig) (filtered []RegisterConfig) {
+	for _, registerConfig := range registerConfigs {
+		if !registerConfig.isWriteOnly() {
+			filtered = append(filtered, registerConfig)
+		}
+	}
+	return
+}
+
+// register generates the register described by a RegisterConfig, using the configuration defaults where not set
+func (registerConfig *RegisterConfig) register(c *Configuration) Register {
+	qos, retain := registerConfig.PublishConfig.resolve(c.PublishConfig)
+	topics := registerConfig.TopicConfig.resolve(c.TopicConfig)
+	return Register{
+		Address:   registerConfig.Address,
+		Slug:      registerConfig.Slug,
+		dataType:  registerConfig.Type,
+		wordOrder: registerConfig.WordOrder,
+		byteOrder: registerConfig.ByteOrder,
+		scale:     registerConfig.Scale,
+		offset:    registerConfig.Offset,
+		precision: registerConfig.Precision,
+		unit:      registerConfig.Unit,
+		qos:       qos,
+		retain:    retain,
+		topic:     c.topic(topics.StateTopic, registerConfig.Slug),
+	}
+}
+
+// registersList generates a list of non-write only registers from a list of register configs
+func (c *Configuration) registersList(registerConfigs []RegisterConfig) (registers []Register) {
+	for _, registerConfig := range filterRegisterConfig(registerConfigs) {
+		registers = append(registers, registerConfig.register(c))
+	}
+	return
+}
+
+// HoldingRegistersList generates a list of non-write only holding registers from a configuration object
+func (c *Configuration) HoldingRegistersList() []Register {
+	return c.registersList(c.HoldingRegisters)
+}
+
+// InputRegistersList generates a list of input registers from a configuration object
+func (c *Configuration) InputRegistersList() []Register {
+	return c.registersList(c.InputRegisters)
+}
+
+// RegisterGroupsList generates a list of groups for both the holding and input registers obtained from the config
+func (c *Configuration) RegisterGroupsList() []RegisterGroup {
+	maxSize := c.maxReadSize(MaxReadRegisters)
+	return append(
+		groupRegisters(c.HoldingRegistersList(), HoldingRegisterTable, maxSize, c.MaxGap),
+		groupRegisters(c.InputRegistersList(), InputRegisterTable, maxSize, c.MaxGap)...,
+	)
 }
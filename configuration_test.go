@@ -3,6 +3,7 @@ package modbridge
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -83,3 +84,362 @@ func TestCoilGroupsListConfiguration(t *testing.T) {
 		t.Errorf("Expected %v, got %v\n", expected, actual)
 	}
 }
+
+func TestRegistersConfiguration(t *testing.T) {
+	input := []byte(`holding_registers:
+- address: 0
+  mode: "RW"
+  slug: "analog-output-1-1"
+input_registers:
+- address: 3
+  mode: "R"
+  slug: "analog-input-1-1"
+- address: 4
+  mode: "R"
+  slug: "analog-input-1-2"`)
+	var c Configuration
+	err := yaml.Unmarshal(input, &c)
+	if err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	if len(c.HoldingRegisters) != 1 || c.HoldingRegisters[0].Slug != "analog-output-1-1" {
+		t.Errorf("Expected parsing 1 holding register, got %v\n", c.HoldingRegisters)
+	}
+	if len(c.InputRegisters) != 2 || c.InputRegisters[1].Address != 4 {
+		t.Errorf("Expected parsing 2 input registers, got %v\n", c.InputRegisters)
+	}
+}
+
+func TestRegisterGroupsListConfiguration(t *testing.T) {
+	c := Configuration{
+		HoldingRegisters: []RegisterConfig{
+			{Address: 1, Mode: ReadWrite}, {Address: 2, Mode: Write},
+		},
+		InputRegisters: []RegisterConfig{
+			{Address: 1, Mode: Read}, {Address: 2, Mode: Read},
+		},
+	}
+	expected := []RegisterGroup{
+		{offset: 1, registers: []Register{{Address: 1}}, table: HoldingRegisterTable},
+		{offset: 1, registers: []Register{{Address: 1}, {Address: 2}}, table: InputRegisterTable},
+	}
+	actual := c.RegisterGroupsList()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, actual)
+	}
+}
+
+func TestDiscreteInputGroupsListConfiguration(t *testing.T) {
+	input := []byte(`discrete_inputs:
+- address: 0
+  slug: "digital-input-1-1"
+- address: 1
+  slug: "digital-input-1-2"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	expected := []CoilGroup{
+		{
+			offset: 0,
+			coils: []Coil{
+				{Address: 0, Slug: "digital-input-1-1", topic: "digital-input-1-1"},
+				{Address: 1, Slug: "digital-input-1-2", topic: "digital-input-1-2"},
+			},
+			table: DiscreteInputTable,
+		},
+	}
+	actual := c.CoilGroupsList()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v\n", expected, actual)
+	}
+}
+
+func TestHoldingRegistersScalingConfiguration(t *testing.T) {
+	input := []byte(`holding_registers:
+- address: 5
+  mode: "RW"
+  slug: "setpoint"
+  scale: 0.1
+  offset: -40
+  precision: 1
+  unit: "°C"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	registers := c.HoldingRegistersList()
+	if len(registers) != 1 {
+		t.Fatalf("Expected a single register, got %v\n", registers)
+	}
+	if register := registers[0]; register.Address != 5 || register.scale != 0.1 || register.offset != -40 || *register.precision != 1 || register.unit != "°C" {
+		t.Errorf("Expected scaling metadata to be parsed, got %v\n", register)
+	}
+}
+
+func TestSwitchTypeConfiguration(t *testing.T) {
+	input := []byte(`coils:
+- address: 0
+  mode: "R"
+  slug: "door-contact"
+  switch_type: "NC"
+- address: 1
+  mode: "R"
+  slug: "push-button"
+discrete_inputs:
+- address: 0
+  slug: "e-stop"
+  switch_type: "NC"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	coils := c.CoilsList()
+	if coils[0].switchType != NC || coils[1].switchType != NO {
+		t.Errorf("Expected NC and NO coils, got %v\n", coils)
+	}
+	if coil := c.CoilsMap()["door-contact"]; coil.switchType != NC {
+		t.Errorf("Expected NC coil in map, got %v\n", coil)
+	}
+	if inputs := c.DiscreteInputsList(); inputs[0].switchType != NC {
+		t.Errorf("Expected NC discrete input, got %v\n", inputs)
+	}
+	if err := yaml.Unmarshal([]byte(`coils: [{switch_type: "NX"}]`), &c); err == nil {
+		t.Errorf("Expected an error for an unknown switch type\n")
+	}
+}
+
+func TestDebounceConfiguration(t *testing.T) {
+	input := []byte(`discrete_inputs:
+- address: 0
+  slug: "push-button"
+  debounce: "50ms"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	if inputs := c.DiscreteInputsList(); inputs[0].debounce != 50*time.Millisecond {
+		t.Errorf("Expected a debounce of 50ms, got %v\n", inputs)
+	}
+}
+
+func TestPublishModeConfiguration(t *testing.T) {
+	input := []byte(`coils:
+- address: 0
+  mode: "R"
+  slug: "door"
+  publish: "state"
+  payloads:
+    on: "open"
+    off: "closed"
+- address: 1
+  mode: "R"
+  slug: "push-button"
+  publish: "both"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	coils := c.CoilsList()
+	if coils[0].publish != State || coils[0].payloads.On != "open" || coils[0].payloads.Off != "closed" {
+		t.Errorf("Expected state publish mode with custom payloads, got %v\n", coils[0])
+	}
+	if coils[1].publish != Both {
+		t.Errorf("Expected both publish mode, got %v\n", coils[1])
+	}
+	if err := yaml.Unmarshal([]byte(`coils: [{publish: "sometimes"}]`), &c); err == nil {
+		t.Errorf("Expected an error for an unknown publish mode\n")
+	}
+}
+
+func TestPublishConfiguration(t *testing.T) {
+	input := []byte(`qos: 1
+retain: true
+coils:
+- address: 0
+  mode: "R"
+  slug: "alarm"
+  qos: 2
+- address: 1
+  mode: "R"
+  slug: "push-button"
+  retain: false
+input_registers:
+- address: 3
+  mode: "R"
+  slug: "temperature"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	coils := c.CoilsList()
+	if coils[0].qos != 2 || !coils[0].retain {
+		t.Errorf("Expected qos 2 and retained alarm, got %v\n", coils[0])
+	}
+	if coils[1].qos != 1 || coils[1].retain {
+		t.Errorf("Expected qos 1 and non-retained push button, got %v\n", coils[1])
+	}
+	if registers := c.InputRegistersList(); registers[0].qos != 1 || !registers[0].retain {
+		t.Errorf("Expected global qos and retain on register, got %v\n", registers[0])
+	}
+}
+
+func TestTopicsConfiguration(t *testing.T) {
+	input := []byte(`topic_prefix: "modbridge"
+device: "neuron"
+state_topic: "{prefix}/{device}/{slug}/state"
+coils:
+- address: 0
+  mode: "RW"
+  slug: "relay-1"
+- address: 1
+  mode: "RW"
+  slug: "relay-2"
+  command_topic: "{prefix}/{device}/relays/2"
+holding_registers:
+- address: 0
+  mode: "RW"
+  slug: "setpoint"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Errorf("Expected no errors parsing example config, got %v\n", err)
+	}
+	if coils := c.CoilsList(); coils[0].topic != "modbridge/neuron/relay-1/state" {
+		t.Errorf("Expected a templated state topic, got %v\n", coils[0].topic)
+	}
+	commands := c.commands()
+	if _, ok := commands["modbridge/neuron/relay-1/set"]; !ok {
+		t.Errorf("Expected a coil mapped to the default command topic, got %v\n", commands)
+	}
+	if _, ok := commands["modbridge/neuron/relays/2"]; !ok {
+		t.Errorf("Expected a coil mapped to the point command topic, got %v\n", commands)
+	}
+	if command, ok := commands["modbridge/neuron/setpoint/set"]; !ok || command.register.topic != "modbridge/neuron/setpoint/state" {
+		t.Errorf("Expected a register mapped to the default command topic, got %v\n", commands)
+	}
+}
+
+func TestDevicesListConfiguration(t *testing.T) {
+	input := []byte(`topic_prefix: "modbridge"
+qos: 1
+devices:
+- name: "neuron"
+  modbus_server_uri: "tcp://neuron.lan:502"
+  unit_id: 1
+  coils:
+  - address: 0
+    mode: "RW"
+    slug: "relay"
+- name: "energy-meter"
+  modbus_server_uri: "rtu:///dev/ttyUSB0?baud=9600"
+  unit_id: 3
+  timeout: 500ms
+  input_registers:
+  - address: 0
+    mode: "R"
+    slug: "voltage"`)
+	var c Configuration
+	if err := yaml.Unmarshal(input, &c); err != nil {
+		t.Fatalf("Expected no errors parsing example config, got %v\n", err)
+	}
+	devices := c.DevicesList()
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %d\n", len(devices))
+	}
+	if devices[0].Device != "neuron" || devices[0].UnitID != 1 || devices[0].ModbusServerURI != "tcp://neuron.lan:502" {
+		t.Errorf("Unexpected first device %v\n", devices[0])
+	}
+	if devices[1].Device != "energy-meter" || devices[1].UnitID != 3 || devices[1].Timeout != 500*time.Millisecond {
+		t.Errorf("Unexpected second device %v\n", devices[1])
+	}
+	// Topics are namespaced per device and global settings are inherited
+	if coils := devices[0].CoilsList(); coils[0].topic != "modbridge/neuron/relay" || coils[0].qos != 1 {
+		t.Errorf("Expected a namespaced topic and inherited qos, got %v\n", coils[0])
+	}
+	if registers := devices[1].InputRegistersList(); registers[0].topic != "modbridge/energy-meter/voltage" {
+		t.Errorf("Expected a namespaced topic, got %v\n", registers[0])
+	}
+	if len(devices[1].Coils) != 0 {
+		t.Errorf("Expected points not to leak across devices, got %v\n", devices[1].Coils)
+	}
+}
+
+func TestDevicesListTopLevelConfiguration(t *testing.T) {
+	c := Configuration{
+		ModbusServerURI: "unipi.lan:502",
+		Coils:           []CoilConfig{{Address: 1, Mode: Read}},
+	}
+	devices := c.DevicesList()
+	if len(devices) != 1 || devices[0].ModbusServerURI != "unipi.lan:502" || len(devices[0].Coils) != 1 {
+		t.Errorf("Expected the top level to form a single device, got %v\n", devices)
+	}
+	c.Devices = []DeviceConfig{{ModbusServerURI: "other.lan:502"}}
+	devices = c.DevicesList()
+	if len(devices) != 2 || devices[1].Device != "device-1" {
+		t.Errorf("Expected the top level device next to a default named device, got %v\n", devices)
+	}
+}
+
+func TestMaxReadSizeConfiguration(t *testing.T) {
+	c := Configuration{
+		MaxReadSize:    2,
+		Coils:          []CoilConfig{{Address: 0, Mode: Read}, {Address: 1, Mode: Read}, {Address: 2, Mode: Read}},
+		InputRegisters: []RegisterConfig{{Address: 0, Mode: Read}, {Address: 1, Mode: Read}, {Address: 2, Mode: Read}},
+	}
+	if groups := c.CoilGroupsList(); len(groups) != 2 {
+		t.Errorf("Expected 2 coil groups, got %v\n", groups)
+	}
+	if groups := c.RegisterGroupsList(); len(groups) != 2 {
+		t.Errorf("Expected 2 register groups, got %v\n", groups)
+	}
+	// Sizes beyond the protocol limits are capped
+	c.MaxReadSize = 1000
+	if groups := c.RegisterGroupsList(); len(groups) != 1 {
+		t.Errorf("Expected 1 register group, got %v\n", groups)
+	}
+}
+
+func TestValidateConfiguration(t *testing.T) {
+	cases := []struct {
+		c     Configuration
+		valid bool
+	}{
+		{c: Configuration{InputRegisters: []RegisterConfig{{Address: 65534, Type: Float32}}}, valid: true},
+		{c: Configuration{InputRegisters: []RegisterConfig{{Address: 65535, Type: Float32}}}, valid: false},
+		{c: Configuration{HoldingRegisters: []RegisterConfig{{Address: 65533, Type: Int64}}}, valid: false},
+		{c: Configuration{MaxReadSize: 2, HoldingRegisters: []RegisterConfig{{Address: 0, Type: Int64}}}, valid: false},
+		{c: Configuration{MaxReadSize: -1}, valid: false},
+		{c: Configuration{Devices: []DeviceConfig{{InputRegisters: []RegisterConfig{{Address: 65535, Type: Int32}}}}}, valid: false},
+		{c: Configuration{BatchTopic: "modbridge/set", Devices: []DeviceConfig{{Name: "neuron"}}}, valid: true},
+		{c: Configuration{BatchTopic: "modbridge/set", Devices: []DeviceConfig{{Name: "neuron"}, {Name: "meter"}}}, valid: false},
+		{c: Configuration{BatchTopic: "modbridge/{device}/set", Devices: []DeviceConfig{{Name: "neuron"}, {Name: "meter"}}}, valid: true},
+		{
+			c: Configuration{TopicConfig: TopicConfig{CommandTopic: "{prefix}/{slug}/set"}, Devices: []DeviceConfig{
+				{Name: "neuron", Coils: []CoilConfig{{Slug: "relay"}}},
+				{Name: "meter", Coils: []CoilConfig{{Slug: "relay"}}},
+			}},
+			valid: false,
+		},
+		{
+			c: Configuration{TopicConfig: TopicConfig{CommandTopic: "{prefix}/{slug}/set"}, Devices: []DeviceConfig{
+				{Name: "neuron", Coils: []CoilConfig{{Slug: "relay-1"}}},
+				{Name: "meter", HoldingRegisters: []RegisterConfig{{Slug: "relay-1"}}},
+			}},
+			valid: false,
+		},
+		{
+			c: Configuration{Devices: []DeviceConfig{
+				{Name: "neuron", Coils: []CoilConfig{{Slug: "relay"}}},
+				{Name: "meter", Coils: []CoilConfig{{Slug: "relay"}}},
+			}},
+			valid: true,
+		},
+		{c: Configuration{DeviceAvailabilityTopic: "modbridge/status", Devices: []DeviceConfig{{Name: "neuron"}, {Name: "meter"}}}, valid: false},
+		{c: Configuration{DeviceAvailabilityTopic: "modbridge/{device}/status", Devices: []DeviceConfig{{Name: "neuron"}, {Name: "meter"}}}, valid: true},
+	}
+	for _, testCase := range cases {
+		if err := testCase.c.Validate(); (err == nil) != testCase.valid {
+			t.Errorf("Expected valid %v, got %v\n", testCase.valid, err)
+		}
+	}
+}
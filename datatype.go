@@ -0,0 +1,225 @@
+package modbridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// DataType indicates how the raw value of one or more registers should be interpreted
+type DataType string
+
+// Data type constants
+const (
+	Uint16  DataType = "uint16" // Default, single register
+	Int16   DataType = "int16"
+	Uint32  DataType = "uint32"
+	Int32   DataType = "int32"
+	Float32 DataType = "float32"
+	Int64   DataType = "int64"
+	Float64 DataType = "float64"
+)
+
+// dataTypeSizes holds the number of registers spanned by each of the data types
+var dataTypeSizes = map[DataType]uint16{
+	"":      1,
+	Uint16:  1,
+	Int16:   1,
+	Uint32:  2,
+	Int32:   2,
+	Float32: 2,
+	Int64:   4,
+	Float64: 4,
+}
+
+// UnmarshalYAML rejects unknown data types when reading in the config
+func (dataType *DataType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	if _, ok := dataTypeSizes[DataType(value)]; !ok {
+		return fmt.Errorf("unknown register type %q", value)
+	}
+	*dataType = DataType(value)
+	return nil
+}
+
+// Size returns the number of registers spanned by the data type
+func (dataType DataType) Size() uint16 {
+	return dataTypeSizes[dataType]
+}
+
+// format interprets raw big-endian data as the given data type and formats it as a string
+func (dataType DataType) format(data []byte) string {
+	switch dataType {
+	case Int16:
+		return strconv.FormatInt(int64(int16(binary.BigEndian.Uint16(data))), 10)
+	case Uint32:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(data)), 10)
+	case Int32:
+		return strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(data))), 10)
+	case Float32:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(data))), 'f', -1, 32)
+	case Int64:
+		return strconv.FormatInt(int64(binary.BigEndian.Uint64(data)), 10)
+	case Float64:
+		return strconv.FormatFloat(math.Float64frombits(binary.BigEndian.Uint64(data)), 'f', -1, 64)
+	default:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint16(data)), 10)
+	}
+}
+
+// value interprets raw big-endian data as the given data type and converts it to a float
+func (dataType DataType) value(data []byte) float64 {
+	switch dataType {
+	case Int16:
+		return float64(int16(binary.BigEndian.Uint16(data)))
+	case Uint32:
+		return float64(binary.BigEndian.Uint32(data))
+	case Int32:
+		return float64(int32(binary.BigEndian.Uint32(data)))
+	case Float32:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case Int64:
+		return float64(int64(binary.BigEndian.Uint64(data)))
+	case Float64:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	default:
+		return float64(binary.BigEndian.Uint16(data))
+	}
+}
+
+// float indicates whether the data type holds floating point values, rather than integers
+func (dataType DataType) float() bool {
+	return dataType == Float32 || dataType == Float64
+}
+
+// bounds returns the range of values of the data type.
+// The upper limit is exclusive, as math.MaxInt64 can't be represented as a float64 and rounds up to 1<<63.
+func (dataType DataType) bounds() (min float64, limit float64) {
+	switch dataType {
+	case Int16:
+		return math.MinInt16, math.MaxInt16 + 1
+	case Uint32:
+		return 0, math.MaxUint32 + 1
+	case Int32:
+		return math.MinInt32, math.MaxInt32 + 1
+	case Int64:
+		return math.MinInt64, 1 << 63
+	case Float32:
+		return -math.MaxFloat32, math.MaxFloat32
+	case Float64:
+		return -math.MaxFloat64, math.MaxFloat64
+	default:
+		return 0, math.MaxUint16 + 1
+	}
+}
+
+// encode converts a value into raw big-endian data of the given data type, rounding and range checking integers
+func (dataType DataType) encode(value float64) ([]byte, error) {
+	data := make([]byte, 2*dataType.Size())
+	switch dataType {
+	case Float32:
+		binary.BigEndian.PutUint32(data, math.Float32bits(float32(value)))
+		return data, nil
+	case Float64:
+		binary.BigEndian.PutUint64(data, math.Float64bits(value))
+		return data, nil
+	}
+
+	rounded := math.Round(value)
+	min, limit := dataType.bounds()
+	if math.IsNaN(rounded) || rounded < min || rounded >= limit {
+		return nil, fmt.Errorf("value %v out of range for %s", value, dataType)
+	}
+
+	switch dataType {
+	case Int16:
+		binary.BigEndian.PutUint16(data, uint16(int16(rounded)))
+	case Uint32:
+		binary.BigEndian.PutUint32(data, uint32(rounded))
+	case Int32:
+		binary.BigEndian.PutUint32(data, uint32(int32(rounded)))
+	case Int64:
+		binary.BigEndian.PutUint64(data, uint64(int64(rounded)))
+	default:
+		binary.BigEndian.PutUint16(data, uint16(rounded))
+	}
+	return data, nil
+}
+
+// encodeInteger converts the text of a whole number into raw big-endian data of the given integer type, exactly and range checked
+func (dataType DataType) encodeInteger(text string) ([]byte, error) {
+	data := make([]byte, 2*dataType.Size())
+	bits := int(16 * dataType.Size())
+	switch dataType {
+	case Int16, Int32, Int64:
+		value, err := strconv.ParseInt(text, 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		switch dataType {
+		case Int16:
+			binary.BigEndian.PutUint16(data, uint16(value))
+		case Int32:
+			binary.BigEndian.PutUint32(data, uint32(value))
+		default:
+			binary.BigEndian.PutUint64(data, uint64(value))
+		}
+	default:
+		value, err := strconv.ParseUint(text, 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		if dataType == Uint32 {
+			binary.BigEndian.PutUint32(data, uint32(value))
+		} else {
+			binary.BigEndian.PutUint16(data, uint16(value))
+		}
+	}
+	return data, nil
+}
+
+// Order indicates the order of the words in a multi-register value, or of the bytes within a register
+type Order string
+
+// Order constants
+const (
+	BigEndian    Order = "big"    // Default, most significant first
+	LittleEndian Order = "little" // Least significant first, e.g. swapped-word float32
+)
+
+// UnmarshalYAML rejects unknown orders when reading in the config
+func (order *Order) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	switch Order(value) {
+	case "", BigEndian, LittleEndian:
+		*order = Order(value)
+		return nil
+	}
+	return fmt.Errorf("unknown order %q", value)
+}
+
+// normalize returns a big-endian copy of raw register data read with the given word and byte order.
+// Since it only swaps words and bytes, it also converts big-endian data back into the given order.
+func normalize(data []byte, wordOrder Order, byteOrder Order) []byte {
+	words := len(data) / 2
+	normalized := make([]byte, 2*words)
+	for k := 0; k < words; k++ {
+		source := k
+		if wordOrder == LittleEndian {
+			source = words - 1 - k
+		}
+		high, low := data[2*source], data[2*source+1]
+		if byteOrder == LittleEndian {
+			high, low = low, high
+		}
+		normalized[2*k], normalized[2*k+1] = high, low
+	}
+	return normalized
+}
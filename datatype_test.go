@@ -0,0 +1,94 @@
+package modbridge
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestDataTypeFormat(t *testing.T) {
+	cases := []struct {
+		dataType DataType
+		data     []byte
+		expected string
+	}{
+		{dataType: Uint16, data: []byte{0xFF, 0xFF}, expected: "65535"},
+		{dataType: Int16, data: []byte{0xFF, 0xFF}, expected: "-1"},
+		{dataType: Uint32, data: []byte{0x00, 0x01, 0x00, 0x00}, expected: "65536"},
+		{dataType: Int32, data: []byte{0xFF, 0xFF, 0xFF, 0xFE}, expected: "-2"},
+		{dataType: Float32, data: []byte{0x43, 0x67, 0x66, 0x66}, expected: "231.4"},
+		{dataType: Int64, data: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFD}, expected: "-3"},
+		{dataType: Float64, data: []byte{0x40, 0x09, 0x21, 0xFB, 0x54, 0x44, 0x2D, 0x18}, expected: "3.141592653589793"},
+	}
+	for _, testCase := range cases {
+		if actual := testCase.dataType.format(testCase.data); actual != testCase.expected {
+			t.Errorf("Expected %s for %s, got %s\n", testCase.expected, testCase.dataType, actual)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x03, 0x04}
+	cases := []struct {
+		wordOrder Order
+		byteOrder Order
+		expected  []byte
+	}{
+		{wordOrder: BigEndian, byteOrder: BigEndian, expected: []byte{0x01, 0x02, 0x03, 0x04}},
+		{wordOrder: LittleEndian, byteOrder: BigEndian, expected: []byte{0x03, 0x04, 0x01, 0x02}},
+		{wordOrder: BigEndian, byteOrder: LittleEndian, expected: []byte{0x02, 0x01, 0x04, 0x03}},
+		{wordOrder: LittleEndian, byteOrder: LittleEndian, expected: []byte{0x04, 0x03, 0x02, 0x01}},
+	}
+	for _, testCase := range cases {
+		if actual := normalize(data, testCase.wordOrder, testCase.byteOrder); !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("Expected %v for %s/%s, got %v\n", testCase.expected, testCase.wordOrder, testCase.byteOrder, actual)
+		}
+	}
+}
+
+func TestDataTypeUnmarshal(t *testing.T) {
+	var registerConfig RegisterConfig
+	if err := yaml.Unmarshal([]byte(`{type: float32, word_order: little}`), &registerConfig); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+	if registerConfig.Type != Float32 || registerConfig.WordOrder != LittleEndian {
+		t.Errorf("Expected float32 with little word order, got %v\n", registerConfig)
+	}
+	if err := yaml.Unmarshal([]byte(`{type: float16}`), &registerConfig); err == nil {
+		t.Errorf("Expected an error for an unknown type\n")
+	}
+	if err := yaml.Unmarshal([]byte(`{byte_order: middle}`), &registerConfig); err == nil {
+		t.Errorf("Expected an error for an unknown byte order\n")
+	}
+}
+
+func TestDataTypeEncode(t *testing.T) {
+	cases := []struct {
+		dataType DataType
+		value    float64
+		expected []byte
+		err      bool
+	}{
+		{dataType: Uint16, value: 2314.4, expected: []byte{0x09, 0x0A}},
+		{dataType: Int16, value: -1, expected: []byte{0xFF, 0xFF}},
+		{dataType: Int32, value: -2, expected: []byte{0xFF, 0xFF, 0xFF, 0xFE}},
+		{dataType: Float32, value: 231.4, expected: []byte{0x43, 0x67, 0x66, 0x66}},
+		{dataType: Uint16, value: 65536, err: true},
+		{dataType: Int16, value: 40000, err: true},
+		{dataType: Uint32, value: -1, err: true},
+		{dataType: Uint32, value: math.MaxUint32, expected: []byte{0xFF, 0xFF, 0xFF, 0xFF}},
+		{dataType: Int64, value: -1 << 63, expected: []byte{0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{dataType: Int64, value: 1 << 63, err: true},
+	}
+	for _, testCase := range cases {
+		actual, err := testCase.dataType.encode(testCase.value)
+		if (err != nil) != testCase.err {
+			t.Errorf("Expected error %v encoding %v as %s, got %v\n", testCase.err, testCase.value, testCase.dataType, err)
+		}
+		if !testCase.err && !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("Expected %v encoding %v as %s, got %v\n", testCase.expected, testCase.value, testCase.dataType, actual)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package modbridge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/goburrow/modbus"
+)
+
+// ErrUnreachable is returned when writing to a device which could not be reached while polling
+var ErrUnreachable = errors.New("modbus device unreachable")
+
+// Device bundles the groups polled from a single modbus device with the points which can be written to it
+type Device struct {
+	Name         string
+	ModbusClient modbus.Client
+	MQTTClient   mqtt.Client
+	schedules    []Schedule
+	commands     map[string]*command
+	batch        *batch
+	handler      io.Closer
+	backoff      *Backoff
+	afterFunc    func(time.Duration, func()) *time.Timer
+	mu           sync.Mutex
+	unreachable  bool
+
+	// Availability as published, only updated from the polling loop, but also read when reconnecting to the broker
+	availabilityTopic string
+	availability      string
+	failures          int
+}
+
+// NewDevice sets up the modbus client and the groups for the configuration of a single device.
+// Devices on the same transport share its connection, taken from the buses.
+func NewDevice(c *Configuration, buses Buses, mqttClient mqtt.Client) (*Device, error) {
+	bus, slaveID, err := buses.Bus(c)
+	if err != nil {
+		return nil, err
+	}
+	// Polling and MQTT writes of all of the devices on the bus share the client through a queue
+	device := newDevice(c, bus.Client(slaveID), mqttClient)
+	// Closing the handler drops a broken connection, so the next request reconnects
+	if closer, ok := bus.handler.(io.Closer); ok {
+		device.handler = closer
+	}
+	return device, nil
+}
+
+// newDevice sets up the groups of a device, attaching a copy of the clients to each of them
+func newDevice(c *Configuration, modbusClient modbus.Client, mqttClient mqtt.Client) *Device {
+	device := &Device{
+		Name:         c.Device,
+		ModbusClient: modbusClient,
+		MQTTClient:   mqttClient,
+		schedules:    c.Schedules(),
+		commands:     c.commands(),
+		batch:        c.batch(),
+		backoff:      NewBackoff(),
+
+		availabilityTopic: c.deviceAvailabilityTopic(),
+	}
+	for _, schedule := range device.schedules {
+		for k := range schedule.CoilGroups {
+			schedule.CoilGroups[k].ModbusClient = modbusClient
+			schedule.CoilGroups[k].MQTTClient = mqttClient
+		}
+		for k := range schedule.RegisterGroups {
+			schedule.RegisterGroups[k].ModbusClient = modbusClient
+			schedule.RegisterGroups[k].MQTTClient = mqttClient
+		}
+	}
+	return device
+}
+
+// Groups returns all of the coil and register groups to poll
+func (device *Device) Groups() (groups []GroupUpdater) {
+	for k := range device.schedules {
+		groups = append(groups, device.schedules[k].Groups()...)
+	}
+	return
+}
+
+// UseSnapshot compares the coils of the device against a snapshot when polling them for the first time, keeping it up to date after that
+func (device *Device) UseSnapshot(snapshot *Snapshot) {
+	for k := range device.schedules {
+		for j := range device.schedules[k].CoilGroups {
+			device.schedules[k].CoilGroups[j].snapshot = snapshot
+		}
+	}
+}
+
+// ReadBlocks describes the blocks of addresses read when polling the device
+func (device *Device) ReadBlocks() (blocks []string) {
+	for k := range device.schedules {
+		schedule := &device.schedules[k]
+		every := "in turn"
+		if schedule.Interval != 0 {
+			every = fmt.Sprintf("every %s", schedule.Interval)
+		}
+		for j := range schedule.CoilGroups {
+			blocks = append(blocks, fmt.Sprintf("%s, %s", &schedule.CoilGroups[j], every))
+		}
+		for j := range schedule.RegisterGroups {
+			blocks = append(blocks, fmt.Sprintf("%s, %s", &schedule.RegisterGroups[j], every))
+		}
+	}
+	return
+}
+
+// CommandTopics returns the topics to subscribe to for writing to the device
+func (device *Device) CommandTopics() (topics []string) {
+	for topic := range device.commands {
+		topics = append(topics, topic)
+	}
+	if device.batch != nil {
+		topics = append(topics, device.batch.topic)
+	}
+	return
+}
+
+// HandleMessage writes the payload of an MQTT command to the matching coil or holding register.
+// Errors are both logged and published on the error topic of the point, or the one of the device for a batch.
+func (device *Device) HandleMessage(client mqtt.Client, msg mqtt.Message) {
+	if err := device.handle(msg.Topic(), msg.Payload()); err != nil {
+		log.Printf("Error %s writing on MQTT event for %s", err, msg.Topic())
+		if command, ok := device.commands[msg.Topic()]; ok {
+			publishError(client, command, err)
+		} else if device.batch != nil && msg.Topic() == device.batch.topic {
+			client.Publish(device.batch.errorTopic, device.batch.qos, false, err.Error())
+		}
+	}
+}
+
+// publishError publishes an error handling a command on the error topic of the point
+func publishError(client mqtt.Client, command *command, err error) {
+	client.Publish(command.errorTopic, command.qos(), false, err.Error())
+}
+
+// Reachable indicates whether the last attempt to poll the device succeeded
+func (device *Device) Reachable() bool {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	return !device.unreachable
+}
+
+// setReachable updates the reachability of the device, logging any changes
+func (device *Device) setReachable(reachable bool) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.unreachable == reachable {
+		log.Printf("%s  -  device %s reachable: %v", time.Now().Format(time.RFC3339), device.Name, reachable)
+	}
+	device.unreachable = !reachable
+}
+
+// setAvailable publishes the retained availability of the device when it changes.
+// The device only goes offline after a number of consecutive failed polls.
+func (device *Device) setAvailable(available bool) {
+	availability := Online
+	if available {
+		device.failures = 0
+	} else {
+		device.failures++
+		if device.failures < offlineAfter {
+			return
+		}
+		availability = Offline
+	}
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.availabilityTopic == "" || device.availability == availability {
+		return
+	}
+	device.availability = availability
+	log.Printf("%s  -  device %s %s", time.Now().Format(time.RFC3339), device.Name, availability)
+	device.MQTTClient.Publish(device.availabilityTopic, 1, true, availability)
+}
+
+// PublishAvailability publishes the current availability of the device again, in case the broker lost the retained one.
+// Nothing is published before the device was polled for the first time.
+func (device *Device) PublishAvailability(client mqtt.Client) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.availabilityTopic == "" || device.availability == "" {
+		return
+	}
+	client.Publish(device.availabilityTopic, 1, true, device.availability)
+}
+
+// handle writes a command payload received on a topic, either for a single point or for a batch of them
+func (device *Device) handle(topic string, payload []byte) (err error) {
+	if device.batch != nil && topic == device.batch.topic {
+		return device.handleBatch(payload)
+	}
+	command, ok := device.commands[topic]
+	if !ok {
+		return
+	}
+	if err := device.allowWrite(command); err != nil {
+		return err
+	}
+	if command.coil != nil {
+		err = device.writeCoil(command, payload)
+	} else {
+		err = device.writeRegister(command, payload)
+	}
+	return
+}
+
+// allowWrite is the single place where writes to read-only points are rejected, as well as writes while the device is unreachable
+func (device *Device) allowWrite(command *command) error {
+	if !command.writable() {
+		return ErrReadOnly
+	}
+	if !device.Reachable() {
+		return ErrUnreachable
+	}
+	return nil
+}
+
+// writeCoil switches a coil according to a command payload
+func (device *Device) writeCoil(command *command, payload []byte) error {
+	coilCommand, err := ParseCoilCommand(payload, command.coil.payloads)
+	if err != nil {
+		return err
+	}
+	if err := command.allowCoilCommand(coilCommand); err != nil {
+		return err
+	}
+	command.mu.Lock()
+	defer command.mu.Unlock()
+	return device.switchCoil(command, coilCommand)
+}
+
+// switchCoil writes a coil, reading its current value first for toggling it.
+// Any pending pulse is cancelled, while switching on for a duration starts a new one.
+func (device *Device) switchCoil(command *command, coilCommand CoilCommand) error {
+	coil := command.coil
+	state := coilCommand.State
+	if state == StateToggle {
+		on, err := device.readCoil(coil)
+		if err != nil {
+			return err
+		}
+		state = StateOn
+		if on {
+			state = StateOff
+		}
+	}
+	if command.pulse != nil {
+		command.pulse.timer.Stop()
+		command.pulse = nil
+	}
+	var value uint16
+	if coil.invert(state == StateOn) {
+		value = 0xFF00
+	}
+	if _, err := device.ModbusClient.WriteSingleCoil(coil.Address, value); err != nil {
+		return err
+	}
+	if duration := coilCommand.Duration; state == StateOn && (duration > 0 || command.autoOff > 0) {
+		if duration == 0 {
+			duration = command.autoOff
+		}
+		device.startPulse(command, duration)
+	}
+	if !command.confirm {
+		return nil
+	}
+	return device.confirmCoil(coil, state == StateOn)
+}
+
+// after calls a function after a duration, through the timer function injected for testing if any
+func (device *Device) after(duration time.Duration, f func()) *time.Timer {
+	if device.afterFunc != nil {
+		return device.afterFunc(duration, f)
+	}
+	return time.AfterFunc(duration, f)
+}
+
+// startPulse schedules switching a coil back off, independent of any MQTT messages
+func (device *Device) startPulse(command *command, duration time.Duration) {
+	pulse := &pulse{backoff: NewBackoff()}
+	pulse.timer = device.after(duration, func() { device.endPulse(command, pulse) })
+	command.pulse = pulse
+}
+
+// endPulse switches a coil back off at the end of a pulse, unless another command came in meanwhile.
+// Failing to switch it off is retried until it succeeds, or until another command replaces the pulse.
+func (device *Device) endPulse(command *command, pulse *pulse) {
+	command.mu.Lock()
+	defer command.mu.Unlock()
+	if command.pulse != pulse {
+		return
+	}
+	command.pulse = nil
+	if err := device.switchCoil(command, CoilCommand{State: StateOff}); err != nil {
+		retry := pulse.backoff.Next()
+		log.Printf("Error %s switching off coil %d, retrying in %s", err, command.coil.Address, retry)
+		publishError(device.MQTTClient, command, err)
+		pulse.timer = device.after(retry, func() { device.endPulse(command, pulse) })
+		command.pulse = pulse
+	}
+}
+
+// readCoil reads whether a single coil is currently on, taking normally closed coils into account
+func (device *Device) readCoil(coil *Coil) (bool, error) {
+	results, err := device.ModbusClient.ReadCoils(coil.Address, 1)
+	if err != nil {
+		return false, err
+	}
+	if len(results) == 0 {
+		return false, fmt.Errorf("no value read for coil %d", coil.Address)
+	}
+	return coil.invert(results[0]&1 != 0), nil
+}
+
+// confirmCoil reads back a coil after switching it, publishing its state when it matches
+func (device *Device) confirmCoil(coil *Coil, on bool) error {
+	value, err := device.readCoil(coil)
+	if err != nil {
+		return fmt.Errorf("reading back coil %d: %s", coil.Address, err)
+	}
+	if value != on {
+		return fmt.Errorf("read back %s from coil %d after switching it %s", coil.payload(value), coil.Address, coil.payload(on))
+	}
+	coil.publishPayload(coil.payload(value), device.MQTTClient)
+	return nil
+}
+
+// writeRegister writes the value of a command payload to a holding register
+func (device *Device) writeRegister(command *command, payload []byte) error {
+	register := command.register
+	if err := register.Write(string(payload), device.ModbusClient); err != nil || !command.confirm {
+		return err
+	}
+	data, _ := register.encode(string(payload))
+	return device.confirmRegister(register, data)
+}
+
+// confirmRegister reads back a holding register after writing it, publishing its value when it matches the data written
+func (device *Device) confirmRegister(register *Register, data []byte) error {
+	results, err := device.ModbusClient.ReadHoldingRegisters(register.Address, register.Size())
+	if err != nil {
+		return fmt.Errorf("reading back register %d: %s", register.Address, err)
+	}
+	if len(results) != len(data) {
+		return fmt.Errorf("read back %d bytes from register %d, expected %d", len(results), register.Address, len(data))
+	}
+	if !bytes.Equal(results, data) {
+		return fmt.Errorf("read back %s from register %d after writing %s", register.format(results), register.Address, register.format(data))
+	}
+	register.publishPayload(register.format(results), device.MQTTClient)
+	return nil
+}
+
+// Poll continuously updates the groups of the device.
+// The groups without a polling interval of their own are updated in turn, one group per interval.
+func (device *Device) Poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	device.poll(ticker.C)
+}
+
+// lane keeps track of polling the groups of a schedule
+type lane struct {
+	interval time.Duration
+	groups   []GroupUpdater
+	next     int
+	due      time.Time
+}
+
+// pending returns the groups of the lane to update at a given tick
+func (lane *lane) pending(now time.Time) []GroupUpdater {
+	if lane.interval == 0 {
+		group := lane.groups[lane.next]
+		lane.next = (lane.next + 1) % len(lane.groups)
+		return []GroupUpdater{group}
+	}
+	if now.Before(lane.due) {
+		return nil
+	}
+	lane.due = now.Add(lane.interval)
+	return lane.groups
+}
+
+// poll updates the groups which are due on each tick.
+// Groups for which the device responds with an exception are skipped.
+// Any other error is considered a connection problem, after which the connection is dropped and retried with a backoff.
+func (device *Device) poll(ticks <-chan time.Time) {
+	var lanes []*lane
+	for k := range device.schedules {
+		lanes = append(lanes, &lane{interval: device.schedules[k].Interval, groups: device.schedules[k].Groups()})
+	}
+	if len(lanes) == 0 {
+		return
+	}
+	var retryAt time.Time
+	for now := range ticks {
+		if now.Before(retryAt) {
+			continue
+		}
+		retryAt = device.update(lanes, now)
+	}
+}
+
+// update polls the pending groups of all lanes, returning when to retry in case the connection failed
+func (device *Device) update(lanes []*lane, now time.Time) time.Time {
+	for _, lane := range lanes {
+		for _, group := range lane.pending(now) {
+			err := group.Update()
+			if err == nil {
+				device.setReachable(true)
+				device.setAvailable(true)
+				device.backoff.Reset()
+				continue
+			}
+			if _, ok := err.(*modbus.ModbusError); ok {
+				log.Printf("%s  -  skipping group for %s: %s", now.Format(time.RFC3339), device.Name, err)
+				continue
+			}
+			device.setReachable(false)
+			device.setAvailable(false)
+			if device.handler != nil {
+				device.handler.Close()
+			}
+			delay := device.backoff.Next()
+			log.Printf("%s  -  error polling %s: %s, retrying in %s", now.Format(time.RFC3339), device.Name, err, delay)
+			return now.Add(delay)
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,446 @@
+package modbridge
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/goburrow/modbus"
+	"github.com/mhemeryck/modbridge/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewDevice(t *testing.T) {
+	c := Configuration{
+		Device:           "neuron",
+		Coils:            []CoilConfig{{Address: 0, Mode: ReadWrite, Slug: "relay"}},
+		HoldingRegisters: []RegisterConfig{{Address: 1, Mode: ReadWrite, Slug: "setpoint"}},
+		InputRegisters:   []RegisterConfig{{Address: 2, Mode: Read, Slug: "temperature"}},
+	}
+	modbusClient := &mocks.ModbusClient{}
+	mqttClient := &mocks.MQTTClient{}
+	device := newDevice(&c, modbusClient, mqttClient)
+	if device.Name != "neuron" {
+		t.Errorf("Expected device name neuron, got %s\n", device.Name)
+	}
+	if groups := device.Groups(); len(groups) != 3 {
+		t.Errorf("Expected 3 groups, got %d\n", len(groups))
+	}
+	if device.schedules[0].CoilGroups[0].ModbusClient != modbusClient || device.schedules[0].RegisterGroups[1].MQTTClient != mqttClient {
+		t.Errorf("Expected clients to be attached to the groups\n")
+	}
+	topics := device.CommandTopics()
+	sort.Strings(topics)
+	if len(topics) != 2 || topics[0] != "neuron/relay/set" || topics[1] != "neuron/setpoint/set" {
+		t.Errorf("Expected command topics for the relay and setpoint, got %v\n", topics)
+	}
+}
+
+func TestNewDeviceSharedBus(t *testing.T) {
+	c := Configuration{
+		ModbusServerURI: "rtu:///dev/ttyNS0?baud=9600&parity=N&stop=2",
+		Devices: []DeviceConfig{
+			{Name: "meter", ModbusServerURI: "rtu:///dev/ttyNS0?baud=9600&parity=N&stop=2", UnitID: 2},
+			{Name: "relays", ModbusServerURI: "rtu:///dev/ttyNS0?baud=9600&parity=N&stop=2", UnitID: 3},
+		},
+	}
+	buses := make(Buses)
+	var queues []*Queue
+	for _, deviceConfig := range c.DevicesList() {
+		device, err := NewDevice(&deviceConfig, buses, &mocks.MQTTClient{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v\n", err)
+		}
+		queues = append(queues, device.ModbusClient.(*Queue))
+	}
+	if len(buses) != 1 || queues[0].writes != queues[1].writes || queues[0].reads != queues[1].reads {
+		t.Errorf("Expected both devices to share a single queue, got %d buses\n", len(buses))
+	}
+}
+
+func TestDeviceHandle(t *testing.T) {
+	cases := []struct {
+		topic   string
+		payload string
+		method  string
+		args    []interface{}
+		err     error
+	}{
+		{topic: "relay/set", payload: "ON", method: "WriteSingleCoil", args: []interface{}{uint16(3), uint16(0xFF00)}},
+		{topic: "relay/set", payload: "OFF", method: "WriteSingleCoil", args: []interface{}{uint16(3), uint16(0x0000)}},
+		{topic: "output/set", payload: "ON", method: "WriteSingleCoil", args: []interface{}{uint16(5), uint16(0xFF00)}},
+		{topic: "button/set", payload: "ON", err: ErrReadOnly},
+		{topic: "setpoint/set", payload: "21.5", method: "WriteSingleRegister", args: []interface{}{uint16(4), uint16(215)}},
+		{topic: "setting/set", payload: "1", err: ErrReadOnly},
+		{topic: "unknown/set", payload: "ON"},
+	}
+	for _, testCase := range cases {
+		modbusClient := &mocks.ModbusClient{}
+		device := &Device{
+			ModbusClient: modbusClient,
+			commands: map[string]*command{
+				"relay/set":    {coil: &Coil{Address: 3}},
+				"output/set":   {mode: Write, coil: &Coil{Address: 5}},
+				"button/set":   {mode: Read, coil: &Coil{Address: 6}},
+				"setpoint/set": {mode: ReadWrite, register: &Register{Address: 4, scale: 0.1}},
+				"setting/set":  {mode: Read, register: &Register{Address: 7}},
+			},
+		}
+		if testCase.method != "" {
+			modbusClient.On(testCase.method, testCase.args...).Return([]byte{}, nil)
+		}
+		if err := device.handle(testCase.topic, []byte(testCase.payload)); err != testCase.err {
+			t.Errorf("Expected error %v for %s, got %v\n", testCase.err, testCase.topic, err)
+		}
+		modbusClient.AssertExpectations(t)
+	}
+}
+
+func TestDeviceHandleToggle(t *testing.T) {
+	cases := []struct {
+		current  byte
+		expected uint16
+	}{
+		{current: 0, expected: 0xFF00},
+		{current: 1, expected: 0x0000},
+	}
+	for _, testCase := range cases {
+		modbusClient := &mocks.ModbusClient{}
+		device := &Device{
+			ModbusClient: modbusClient,
+			commands:     map[string]*command{"relay/set": {coil: &Coil{Address: 3}}},
+		}
+		modbusClient.On("ReadCoils", uint16(3), uint16(1)).Return([]byte{testCase.current}, nil)
+		modbusClient.On("WriteSingleCoil", uint16(3), testCase.expected).Return([]byte{}, nil)
+		if err := device.handle("relay/set", []byte("TOGGLE")); err != nil {
+			t.Errorf("Expected no error, got %v\n", err)
+		}
+		modbusClient.AssertExpectations(t)
+	}
+}
+
+func TestDeviceHandleToggleWriteOnly(t *testing.T) {
+	modbusClient := &mocks.ModbusClient{}
+	device := &Device{
+		ModbusClient: modbusClient,
+		commands:     map[string]*command{"relay/set": {mode: Write, coil: &Coil{Address: 3}}},
+	}
+	if err := device.handle("relay/set", []byte("TOGGLE")); err != ErrWriteOnly {
+		t.Errorf("Expected %v, got %v\n", ErrWriteOnly, err)
+	}
+	modbusClient.AssertNotCalled(t, "ReadCoils", uint16(3), uint16(1))
+}
+
+func TestDeviceHandleInvalidPayload(t *testing.T) {
+	modbusClient := &mocks.ModbusClient{}
+	device := &Device{
+		ModbusClient: modbusClient,
+		commands:     map[string]*command{"relay/set": {coil: &Coil{Address: 3}}},
+	}
+	if err := device.handle("relay/set", []byte("onn")); err == nil {
+		t.Errorf("Expected an error for an invalid payload\n")
+	}
+	modbusClient.AssertNotCalled(t, "WriteSingleCoil", mock.Anything, mock.Anything)
+}
+
+func TestDeviceHandleConfirm(t *testing.T) {
+	cases := []struct {
+		topic    string
+		payload  string
+		write    string
+		read     string
+		args     []interface{}
+		results  []byte
+		readErr  error
+		expected string
+		valid    bool
+	}{
+		{topic: "relay/set", payload: "ON", write: "WriteSingleCoil", read: "ReadCoils", args: []interface{}{uint16(3), uint16(0xFF00)}, results: []byte{1}, expected: "ON", valid: true},
+		{topic: "relay/set", payload: "ON", write: "WriteSingleCoil", read: "ReadCoils", args: []interface{}{uint16(3), uint16(0xFF00)}, results: []byte{0}, valid: false},
+		{topic: "relay/set", payload: "OFF", write: "WriteSingleCoil", read: "ReadCoils", args: []interface{}{uint16(3), uint16(0)}, readErr: errors.New("timeout"), valid: false},
+		// Normally closed coils are written inverted, and read back the same way
+		{topic: "lock/set", payload: "ON", write: "WriteSingleCoil", read: "ReadCoils", args: []interface{}{uint16(5), uint16(0)}, results: []byte{0}, expected: "ON", valid: true},
+		{topic: "lock/set", payload: "OFF", write: "WriteSingleCoil", read: "ReadCoils", args: []interface{}{uint16(5), uint16(0xFF00)}, results: []byte{1}, expected: "OFF", valid: true},
+		{topic: "lock/set", payload: "ON", write: "WriteSingleCoil", read: "ReadCoils", args: []interface{}{uint16(5), uint16(0)}, results: []byte{1}, valid: false},
+		{topic: "setpoint/set", payload: "21.5", write: "WriteSingleRegister", read: "ReadHoldingRegisters", args: []interface{}{uint16(4), uint16(215)}, results: []byte{0, 215}, expected: "21.5", valid: true},
+		{topic: "setpoint/set", payload: "21.5", write: "WriteSingleRegister", read: "ReadHoldingRegisters", args: []interface{}{uint16(4), uint16(215)}, results: []byte{0, 200}, valid: false},
+	}
+	for _, testCase := range cases {
+		modbusClient := &mocks.ModbusClient{}
+		mqttClient := &mocks.MQTTClient{}
+		device := &Device{
+			ModbusClient: modbusClient,
+			MQTTClient:   mqttClient,
+			commands: map[string]*command{
+				"relay/set":    {confirm: true, coil: &Coil{Address: 3, Slug: "relay"}},
+				"lock/set":     {confirm: true, coil: &Coil{Address: 5, Slug: "lock", switchType: NC}},
+				"setpoint/set": {confirm: true, register: &Register{Address: 4, Slug: "setpoint", scale: 0.1}},
+			},
+		}
+		modbusClient.On(testCase.write, testCase.args...).Return([]byte{}, nil)
+		modbusClient.On(testCase.read, testCase.args[0], uint16(1)).Return(testCase.results, testCase.readErr)
+		if testCase.expected != "" {
+			mqttClient.On("Publish", strings.TrimSuffix(testCase.topic, "/set"), byte(0), false, testCase.expected).Return(&mqtt.PublishToken{})
+		}
+		if err := device.handle(testCase.topic, []byte(testCase.payload)); (err == nil) != testCase.valid {
+			t.Errorf("Expected valid %v for %s, got %v\n", testCase.valid, testCase.payload, err)
+		}
+		modbusClient.AssertExpectations(t)
+		mqttClient.AssertExpectations(t)
+	}
+}
+
+func TestDeviceConfirmThenPoll(t *testing.T) {
+	yes := true
+	c := Configuration{Coils: []CoilConfig{{Address: 5, Mode: ReadWrite, Slug: "lock", SwitchType: NC, Publish: State, Confirm: &yes}}}
+	var published []string
+	modbusClient := &mocks.ModbusClient{}
+	mqttClient := &mocks.MQTTClient{}
+	mqttClient.On("Publish", "lock", byte(0), false, mock.Anything).Return(&mqtt.PublishToken{}).Run(func(args mock.Arguments) {
+		published = append(published, args.String(3))
+	})
+	device := newDevice(&c, modbusClient, mqttClient)
+	group := &device.schedules[0].CoilGroups[0]
+
+	// Seeded with the coil closed, which is off for a normally closed coil
+	modbusClient.On("ReadCoils", uint16(5), uint16(1)).Return([]byte{1}, nil).Once()
+	group.Update()
+
+	// Switching it on opens the coil, both the echo and the next poll publish it as on
+	modbusClient.On("WriteSingleCoil", uint16(5), uint16(0)).Return([]byte{}, nil).Once()
+	modbusClient.On("ReadCoils", uint16(5), uint16(1)).Return([]byte{0}, nil).Twice()
+	if err := device.handle("lock/set", []byte("ON")); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+	group.Update()
+	if !reflect.DeepEqual(published, []string{"OFF", "ON", "ON"}) {
+		t.Errorf("Expected the state to go from OFF to ON without flapping, got %v\n", published)
+	}
+	modbusClient.AssertExpectations(t)
+}
+
+func TestDeviceHandlePulse(t *testing.T) {
+	cases := []struct {
+		payload  string
+		autoOff  time.Duration
+		expected time.Duration
+	}{
+		{payload: `{"state":"ON","duration":"500ms"}`, expected: 500 * time.Millisecond},
+		{payload: "ON", autoOff: time.Minute, expected: time.Minute},
+		{payload: `{"state":"ON","duration":"2s"}`, autoOff: time.Minute, expected: 2 * time.Second},
+	}
+	for _, testCase := range cases {
+		var delay time.Duration
+		var fire func()
+		modbusClient := &mocks.ModbusClient{}
+		device := &Device{
+			ModbusClient: modbusClient,
+			commands:     map[string]*command{"relay/set": {autoOff: testCase.autoOff, coil: &Coil{Address: 3}}},
+			afterFunc: func(duration time.Duration, f func()) *time.Timer {
+				delay, fire = duration, f
+				return time.NewTimer(time.Hour)
+			},
+		}
+		modbusClient.On("WriteSingleCoil", uint16(3), uint16(0xFF00)).Return([]byte{}, nil).Once()
+		if err := device.handle("relay/set", []byte(testCase.payload)); err != nil {
+			t.Errorf("Expected no error, got %v\n", err)
+		}
+		if delay != testCase.expected || fire == nil {
+			t.Fatalf("Expected a pulse of %s, got %s\n", testCase.expected, delay)
+		}
+		// The coil is switched back off when the timer fires
+		modbusClient.On("WriteSingleCoil", uint16(3), uint16(0)).Return([]byte{}, nil).Once()
+		fire()
+		modbusClient.AssertExpectations(t)
+	}
+}
+
+func TestDeviceHandlePulseRetry(t *testing.T) {
+	var delays []time.Duration
+	var fire func()
+	modbusClient := &mocks.ModbusClient{}
+	mqttClient := &mocks.MQTTClient{}
+	device := &Device{
+		ModbusClient: modbusClient,
+		MQTTClient:   mqttClient,
+		commands:     map[string]*command{"relay/set": {errorTopic: "relay/error", coil: &Coil{Address: 3}}},
+		afterFunc: func(duration time.Duration, f func()) *time.Timer {
+			delays, fire = append(delays, duration), f
+			return time.NewTimer(time.Hour)
+		},
+	}
+	modbusClient.On("WriteSingleCoil", uint16(3), uint16(0xFF00)).Return([]byte{}, nil).Once()
+	if err := device.handle("relay/set", []byte(`{"state":"ON","duration":"1s"}`)); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+
+	// The first switch off fails, and is retried after a backoff
+	modbusClient.On("WriteSingleCoil", uint16(3), uint16(0)).Return([]byte{}, errors.New("timeout")).Once()
+	mqttClient.On("Publish", "relay/error", byte(0), false, "timeout").Return(&mqtt.PublishToken{}).Once()
+	fire()
+	if len(delays) != 2 || delays[1] <= 0 || device.commands["relay/set"].pulse == nil {
+		t.Fatalf("Expected the switch off to be retried, got delays %v\n", delays)
+	}
+	modbusClient.On("WriteSingleCoil", uint16(3), uint16(0)).Return([]byte{}, nil).Once()
+	fire()
+	if len(delays) != 2 || device.commands["relay/set"].pulse != nil {
+		t.Errorf("Expected no more retries once switched off, got delays %v\n", delays)
+	}
+	modbusClient.AssertExpectations(t)
+	mqttClient.AssertExpectations(t)
+}
+
+func TestDeviceHandlePulseCancel(t *testing.T) {
+	var fire func()
+	modbusClient := &mocks.ModbusClient{}
+	device := &Device{
+		ModbusClient: modbusClient,
+		commands:     map[string]*command{"relay/set": {coil: &Coil{Address: 3}}},
+		afterFunc: func(duration time.Duration, f func()) *time.Timer {
+			fire = f
+			return time.NewTimer(time.Hour)
+		},
+	}
+	modbusClient.On("WriteSingleCoil", uint16(3), uint16(0xFF00)).Return([]byte{}, nil).Twice()
+	if err := device.handle("relay/set", []byte(`{"state":"ON","duration":"1s"}`)); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+	// A plain ON keeps the coil on, so the earlier pulse doesn't switch it off anymore
+	if err := device.handle("relay/set", []byte("ON")); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+	fire()
+	modbusClient.AssertExpectations(t)
+	modbusClient.AssertNotCalled(t, "WriteSingleCoil", uint16(3), uint16(0))
+}
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+func (msg *message) Duplicate() bool   { return false }
+func (msg *message) Qos() byte         { return 0 }
+func (msg *message) Retained() bool    { return false }
+func (msg *message) Topic() string     { return msg.topic }
+func (msg *message) MessageID() uint16 { return 0 }
+func (msg *message) Payload() []byte   { return msg.payload }
+func (msg *message) Ack()              {}
+
+func TestDeviceHandleMessageError(t *testing.T) {
+	c := Configuration{
+		TopicPrefix: "modbridge",
+		Coils:       []CoilConfig{{Address: 0, Mode: Read, Slug: "button", PublishConfig: PublishConfig{QoS: new(QoS)}}},
+	}
+	*c.Coils[0].QoS = 1
+	modbusClient := &mocks.ModbusClient{}
+	mqttClient := &mocks.MQTTClient{}
+	device := newDevice(&c, modbusClient, mqttClient)
+	mqttClient.On("Publish", "modbridge/button/error", byte(1), false, ErrReadOnly.Error()).Return(&mqtt.PublishToken{})
+
+	device.HandleMessage(mqttClient, &message{topic: "modbridge/button/set", payload: []byte("ON")})
+	mqttClient.AssertExpectations(t)
+	modbusClient.AssertNotCalled(t, "WriteSingleCoil", mock.Anything, mock.Anything)
+}
+
+type closer struct {
+	closed int
+}
+
+func (c *closer) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestDevicePoll(t *testing.T) {
+	modbusClient := &mocks.ModbusClient{}
+	mqttClient := &mocks.MQTTClient{}
+	handler := &closer{}
+	c := Configuration{
+		Coils:            []CoilConfig{{Address: 0, Mode: ReadWrite, Slug: "button"}},
+		HoldingRegisters: []RegisterConfig{{Address: 0, Mode: Read, Slug: "setpoint"}},
+		InputRegisters:   []RegisterConfig{{Address: 0, Mode: Read, Slug: "temperature"}},
+	}
+	device := newDevice(&c, modbusClient, mqttClient)
+	device.handler = handler
+	device.backoff = &Backoff{Min: time.Second, Max: time.Minute, Factor: 2}
+	modbusClient.On("ReadCoils", uint16(0), uint16(1)).Return([]byte{0}, nil)
+	modbusClient.On("ReadHoldingRegisters", uint16(0), uint16(1)).Return(nil, &modbus.ModbusError{FunctionCode: 3, ExceptionCode: 2})
+	modbusClient.On("ReadInputRegisters", uint16(0), uint16(1)).Return(nil, errors.New("bzzt"))
+	mqttClient.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&mqtt.PublishToken{})
+
+	// The exception skips the holding register group, the connection error backs off for a second
+	start := time.Now()
+	ticks := make(chan time.Time, 4)
+	for k := 0; k < 4; k++ {
+		ticks <- start.Add(time.Duration(k) * 400 * time.Millisecond)
+	}
+	close(ticks)
+	device.poll(ticks)
+	modbusClient.AssertNumberOfCalls(t, "ReadCoils", 1)
+	modbusClient.AssertNumberOfCalls(t, "ReadHoldingRegisters", 1)
+	modbusClient.AssertNumberOfCalls(t, "ReadInputRegisters", 1)
+	if handler.closed != 1 {
+		t.Errorf("Expected the connection to be closed once, got %d\n", handler.closed)
+	}
+	if device.Reachable() {
+		t.Errorf("Expected the device to be unreachable\n")
+	}
+	if err := device.handle("button/set", []byte("ON")); err != ErrUnreachable {
+		t.Errorf("Expected writes to fail while unreachable, got %v\n", err)
+	}
+
+	// Once the device responds again, writes are accepted again
+	ticks = make(chan time.Time, 1)
+	ticks <- start.Add(2 * time.Second)
+	close(ticks)
+	device.poll(ticks)
+	modbusClient.AssertNumberOfCalls(t, "ReadCoils", 2)
+	if !device.Reachable() {
+		t.Errorf("Expected the device to be reachable again\n")
+	}
+}
+
+func TestDevicePollIntervals(t *testing.T) {
+	modbusClient := &mocks.ModbusClient{}
+	mqttClient := &mocks.MQTTClient{}
+	c := Configuration{
+		Coils: []CoilConfig{
+			{Address: 0, Mode: Read, Slug: "button-1"},
+			{Address: 2, Mode: Read, Slug: "button-2"},
+		},
+		InputRegisters: []RegisterConfig{{Address: 0, Mode: Read, Slug: "temperature", PollInterval: time.Second}},
+	}
+	device := newDevice(&c, modbusClient, mqttClient)
+	modbusClient.On("ReadCoils", mock.Anything, uint16(1)).Return([]byte{0}, nil)
+	modbusClient.On("ReadInputRegisters", uint16(0), uint16(1)).Return([]byte{0, 0}, nil)
+	mqttClient.On("Publish", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&mqtt.PublishToken{})
+
+	// The buttons are polled in turn on every tick, the temperature once per second
+	start := time.Now()
+	ticks := make(chan time.Time, 6)
+	for k := 0; k < 6; k++ {
+		ticks <- start.Add(time.Duration(k) * 250 * time.Millisecond)
+	}
+	close(ticks)
+	device.poll(ticks)
+	modbusClient.AssertNumberOfCalls(t, "ReadCoils", 6)
+	modbusClient.AssertNumberOfCalls(t, "ReadInputRegisters", 2)
+}
+
+func TestDeviceReadBlocks(t *testing.T) {
+	c := Configuration{
+		MaxGap:           1,
+		Coils:            []CoilConfig{{Address: 0, Mode: Read}, {Address: 2, Mode: Read}},
+		HoldingRegisters: []RegisterConfig{{Address: 100, Mode: Read, PollInterval: time.Minute}},
+	}
+	device := newDevice(&c, &mocks.ModbusClient{}, &mocks.MQTTClient{})
+	expected := []string{"coils 0-2 (1 unused), in turn", "holding registers 100-100 (0 unused), every 1m0s"}
+	if blocks := device.ReadBlocks(); !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected read blocks %v, got %v\n", expected, blocks)
+	}
+}
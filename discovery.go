@@ -0,0 +1,182 @@
+package modbridge
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultDiscoveryPrefix is the topic prefix Home Assistant listens on for discovery payloads
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// DiscoveryConfig holds the settings for publishing Home Assistant MQTT discovery payloads
+type DiscoveryConfig struct {
+	Enabled      bool
+	Prefix       string
+	NodeID       string `yaml:"node_id"`
+	Manufacturer string
+	Model        string
+}
+
+// Discovery represents a single Home Assistant discovery payload for one point
+type Discovery struct {
+	Component string
+	ObjectID  string
+	Payload   map[string]interface{}
+}
+
+// Topic returns the discovery topic of the form <prefix>/<component>/<node>/<object>/config
+func (discovery *Discovery) Topic(prefix string, nodeID string) string {
+	return orDefault(prefix, DefaultDiscoveryPrefix) + "/" + discovery.Component + "/" + nodeID + "/" + discovery.ObjectID + "/config"
+}
+
+// nodeID returns the node ID used in the discovery topics and unique IDs
+func (c *Configuration) nodeID() string {
+	return orDefault(c.Discovery.NodeID, orDefault(c.Device, orDefault(c.MQTTClientID, "modbridge")))
+}
+
+// discoveryDevice returns the device metadata shared by all of the discovery payloads
+func (c *Configuration) discoveryDevice() map[string]interface{} {
+	device := map[string]interface{}{
+		"identifiers": []string{c.nodeID()},
+		"name":        orDefault(c.Device, c.nodeID()),
+	}
+	if c.Discovery.Manufacturer != "" {
+		device["manufacturer"] = c.Discovery.Manufacturer
+	}
+	if c.Discovery.Model != "" {
+		device["model"] = c.Discovery.Model
+	}
+	return device
+}
+
+// discovery creates a discovery payload for a point, filling in the fields common to all components
+func (c *Configuration) discovery(component string, objectID string, payload map[string]interface{}) Discovery {
+	payload["device"] = c.discoveryDevice()
+	if component != "device_automation" {
+		payload["name"] = objectID
+		payload["unique_id"] = c.nodeID() + "_" + objectID
+	}
+	// With both the bridge and the device announcing their availability, the point is only available when both are
+	switch topics := c.availabilityTopics(); len(topics) {
+	case 1:
+		payload["availability_topic"] = topics[0]
+	case 2:
+		payload["availability"] = []map[string]string{{"topic": topics[0]}, {"topic": topics[1]}}
+		payload["availability_mode"] = "all"
+	}
+	return Discovery{Component: component, ObjectID: objectID, Payload: payload}
+}
+
+// coilDiscoveries generates the discovery payloads for a coil or discrete input
+func (c *Configuration) coilDiscoveries(coilConfig CoilConfig, writable bool) []Discovery {
+	topics := coilConfig.TopicConfig.resolve(c.TopicConfig)
+	stateTopic := c.topic(topics.StateTopic, coilConfig.Slug)
+	hasState := coilConfig.Publish == State || coilConfig.Publish == Periodic
+	payloadOn, payloadOff := orDefault(coilConfig.Payloads.On, "ON"), orDefault(coilConfig.Payloads.Off, "OFF")
+
+	// Writable coils become switches, which are optimistic unless we publish their state.
+	// Normally closed coils are inverted both when writing and polling them, so they need no special payloads.
+	if writable {
+		payload := map[string]interface{}{
+			"command_topic": c.topic(topics.CommandTopic, coilConfig.Slug),
+			"payload_on":    "ON",
+			"payload_off":   "OFF",
+		}
+		if coilConfig.Mode != Write && hasState {
+			payload["state_topic"] = stateTopic
+			payload["state_on"] = payloadOn
+			payload["state_off"] = payloadOff
+		}
+		return []Discovery{c.discovery("switch", coilConfig.Slug, payload)}
+	}
+
+	// Read coils publishing their state become binary sensors
+	if hasState {
+		payload := map[string]interface{}{
+			"state_topic": stateTopic,
+			"payload_on":  payloadOn,
+			"payload_off": payloadOff,
+		}
+		return []Discovery{c.discovery("binary_sensor", coilConfig.Slug, payload)}
+	}
+
+	// Read coils publishing events become device triggers
+	trigger := func(objectID string, triggerType string, event string) Discovery {
+		return c.discovery("device_automation", objectID, map[string]interface{}{
+			"automation_type": "trigger",
+			"topic":           stateTopic,
+			"type":            triggerType,
+			"subtype":         coilConfig.Slug,
+			"payload":         event,
+		})
+	}
+	if coilConfig.Publish == Gesture {
+		return []Discovery{
+			trigger(coilConfig.Slug+"_single", "button_short_press", orDefault(coilConfig.Payloads.Single, "single")),
+			trigger(coilConfig.Slug+"_double", "button_double_press", orDefault(coilConfig.Payloads.Double, "double")),
+			trigger(coilConfig.Slug+"_long", "button_long_press", orDefault(coilConfig.Payloads.Long, "long")),
+			trigger(coilConfig.Slug+"_hold", "button_hold", orDefault(coilConfig.Payloads.Hold, "hold")),
+		}
+	}
+	if coilConfig.Publish == Both {
+		return []Discovery{
+			trigger(coilConfig.Slug+"_press", "button_short_press", orDefault(coilConfig.Payloads.Press, "press")),
+			trigger(coilConfig.Slug+"_release", "button_short_release", orDefault(coilConfig.Payloads.Release, "release")),
+		}
+	}
+	return []Discovery{trigger(coilConfig.Slug, "button_short_press", orDefault(coilConfig.Payloads.Trigger, "trigger"))}
+}
+
+// registerDiscovery generates the discovery payload for a holding or input register
+func (c *Configuration) registerDiscovery(registerConfig RegisterConfig, writable bool) Discovery {
+	topics := registerConfig.TopicConfig.resolve(c.TopicConfig)
+	payload := map[string]interface{}{}
+	if registerConfig.Mode != Write {
+		payload["state_topic"] = c.topic(topics.StateTopic, registerConfig.Slug)
+	}
+	if registerConfig.Unit != "" {
+		payload["unit_of_measurement"] = registerConfig.Unit
+	}
+	if !writable {
+		return c.discovery("sensor", registerConfig.Slug, payload)
+	}
+	payload["command_topic"] = c.topic(topics.CommandTopic, registerConfig.Slug)
+	// Without a range, Home Assistant only allows whole numbers from 1 to 100
+	register := registerConfig.register(c)
+	payload["min"], payload["max"], payload["step"] = register.numberRange()
+	return c.discovery("number", registerConfig.Slug, payload)
+}
+
+// DiscoveryList generates the Home Assistant discovery payloads for all of the configured points
+func (c *Configuration) DiscoveryList() (discoveries []Discovery) {
+	for _, coilConfig := range c.Coils {
+		discoveries = append(discoveries, c.coilDiscoveries(coilConfig, coilConfig.Mode != Read)...)
+	}
+	for _, inputConfig := range c.DiscreteInputs {
+		discoveries = append(discoveries, c.coilDiscoveries(inputConfig, false)...)
+	}
+	for _, registerConfig := range c.HoldingRegisters {
+		discoveries = append(discoveries, c.registerDiscovery(registerConfig, registerConfig.Mode != Read))
+	}
+	for _, registerConfig := range c.InputRegisters {
+		discoveries = append(discoveries, c.registerDiscovery(registerConfig, false))
+	}
+	return
+}
+
+// PublishDiscovery publishes the retained Home Assistant discovery payloads for all of the configured points
+func (c *Configuration) PublishDiscovery(mqttClient mqtt.Client) (err error) {
+	for _, discovery := range c.DiscoveryList() {
+		payload, err := json.Marshal(discovery.Payload)
+		if err != nil {
+			return err
+		}
+		topic := discovery.Topic(c.Discovery.Prefix, c.nodeID())
+		log.Printf("%s  -  discovery for %s", time.Now().Format(time.RFC3339), topic)
+		mqttClient.Publish(topic, 1, true, payload)
+	}
+	return
+}
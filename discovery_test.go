@@ -0,0 +1,192 @@
+package modbridge
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mhemeryck/modbridge/mocks"
+	"github.com/stretchr/testify/mock"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const discoveryConfig = `device: "neuron"
+availability_topic: "neuron/status"
+discovery:
+  enabled: true
+  manufacturer: "Unipi"
+  model: "L303"
+coils:
+- address: 0
+  mode: "RW"
+  slug: "relay"
+  publish: "state"
+- address: 1
+  mode: "W"
+  slug: "output"
+- address: 2
+  mode: "R"
+  slug: "button"
+  publish: "both"
+discrete_inputs:
+- address: 0
+  slug: "door"
+  publish: "state"
+- address: 1
+  slug: "doorbell"
+holding_registers:
+- address: 0
+  mode: "RW"
+  slug: "setpoint"
+  unit: "°C"
+input_registers:
+- address: 0
+  mode: "R"
+  slug: "temperature"
+  unit: "°C"`
+
+func TestDiscoveryList(t *testing.T) {
+	var c Configuration
+	if err := yaml.Unmarshal([]byte(discoveryConfig), &c); err != nil {
+		t.Fatalf("Expected no errors parsing example config, got %v\n", err)
+	}
+	device := map[string]interface{}{"identifiers": []string{"neuron"}, "name": "neuron", "manufacturer": "Unipi", "model": "L303"}
+	expected := []Discovery{
+		{Component: "switch", ObjectID: "relay", Payload: map[string]interface{}{
+			"name": "relay", "unique_id": "neuron_relay", "device": device, "availability_topic": "neuron/status",
+			"command_topic": "neuron/relay/set", "payload_on": "ON", "payload_off": "OFF",
+			"state_topic": "neuron/relay", "state_on": "ON", "state_off": "OFF",
+		}},
+		{Component: "switch", ObjectID: "output", Payload: map[string]interface{}{
+			"name": "output", "unique_id": "neuron_output", "device": device, "availability_topic": "neuron/status",
+			"command_topic": "neuron/output/set", "payload_on": "ON", "payload_off": "OFF",
+		}},
+		{Component: "device_automation", ObjectID: "button_press", Payload: map[string]interface{}{
+			"device": device, "availability_topic": "neuron/status",
+			"automation_type": "trigger", "topic": "neuron/button", "type": "button_short_press", "subtype": "button", "payload": "press",
+		}},
+		{Component: "device_automation", ObjectID: "button_release", Payload: map[string]interface{}{
+			"device": device, "availability_topic": "neuron/status",
+			"automation_type": "trigger", "topic": "neuron/button", "type": "button_short_release", "subtype": "button", "payload": "release",
+		}},
+		{Component: "binary_sensor", ObjectID: "door", Payload: map[string]interface{}{
+			"name": "door", "unique_id": "neuron_door", "device": device, "availability_topic": "neuron/status",
+			"state_topic": "neuron/door", "payload_on": "ON", "payload_off": "OFF",
+		}},
+		{Component: "device_automation", ObjectID: "doorbell", Payload: map[string]interface{}{
+			"device": device, "availability_topic": "neuron/status",
+			"automation_type": "trigger", "topic": "neuron/doorbell", "type": "button_short_press", "subtype": "doorbell", "payload": "trigger",
+		}},
+		{Component: "number", ObjectID: "setpoint", Payload: map[string]interface{}{
+			"name": "setpoint", "unique_id": "neuron_setpoint", "device": device, "availability_topic": "neuron/status",
+			"state_topic": "neuron/setpoint", "command_topic": "neuron/setpoint/set", "unit_of_measurement": "°C",
+			"min": 0.0, "max": 65535.0, "step": 1.0,
+		}},
+		{Component: "sensor", ObjectID: "temperature", Payload: map[string]interface{}{
+			"name": "temperature", "unique_id": "neuron_temperature", "device": device, "availability_topic": "neuron/status",
+			"state_topic": "neuron/temperature", "unit_of_measurement": "°C",
+		}},
+	}
+	actual := c.DiscoveryList()
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d discovery payloads, got %d\n", len(expected), len(actual))
+	}
+	for k := range expected {
+		if !reflect.DeepEqual(actual[k], expected[k]) {
+			t.Errorf("Expected %v, got %v\n", expected[k], actual[k])
+		}
+	}
+}
+
+func TestDiscoveryNormallyClosedSwitch(t *testing.T) {
+	// Normally closed coils are inverted both when writing and polling them, so the switch uses the same payloads
+	c := Configuration{Device: "neuron", Coils: []CoilConfig{{Address: 0, Mode: ReadWrite, Slug: "lock", SwitchType: NC, Publish: State}}}
+	payload := c.DiscoveryList()[0].Payload
+	for key, expected := range map[string]string{"payload_on": "ON", "payload_off": "OFF", "state_on": "ON", "state_off": "OFF"} {
+		if payload[key] != expected {
+			t.Errorf("Expected %s %s for a normally closed switch, got %v\n", key, expected, payload[key])
+		}
+	}
+}
+
+func TestDiscoveryNumberRange(t *testing.T) {
+	one := 1
+	cases := []struct {
+		registerConfig RegisterConfig
+		min            float64
+		max            float64
+		step           float64
+	}{
+		{registerConfig: RegisterConfig{Slug: "setpoint"}, min: 0, max: 65535, step: 1},
+		{registerConfig: RegisterConfig{Slug: "setpoint", Type: Int16, Scale: 0.1, Precision: &one}, min: -3276.8, max: 3276.7, step: 0.1},
+		{registerConfig: RegisterConfig{Slug: "setpoint", Scale: 0.5, Offset: -40}, min: -40, max: 32727.5, step: 0.5},
+		{registerConfig: RegisterConfig{Slug: "setpoint", Scale: -1}, min: -65535, max: 0, step: 1},
+		{registerConfig: RegisterConfig{Slug: "setpoint", Type: Float32, Precision: &one}, min: -math.MaxFloat32, max: math.MaxFloat32, step: 0.1},
+		{registerConfig: RegisterConfig{Slug: "setpoint", Type: Float64, Scale: 10}, min: -math.MaxFloat64, max: math.MaxFloat64, step: 0.001},
+	}
+	for _, testCase := range cases {
+		c := Configuration{Device: "neuron", HoldingRegisters: []RegisterConfig{testCase.registerConfig}}
+		payload := c.DiscoveryList()[0].Payload
+		for key, expected := range map[string]float64{"min": testCase.min, "max": testCase.max, "step": testCase.step} {
+			if actual := payload[key].(float64); math.Abs(actual-expected) > 1e-9*math.Max(1, math.Abs(expected)) {
+				t.Errorf("Expected %s %v for %v, got %v\n", key, expected, testCase.registerConfig, actual)
+			}
+		}
+		if _, err := json.Marshal(payload); err != nil {
+			t.Errorf("Expected no error marshalling %v, got %v\n", payload, err)
+		}
+	}
+}
+
+func TestDiscoveryTopic(t *testing.T) {
+	discovery := Discovery{Component: "binary_sensor", ObjectID: "door"}
+	if topic := discovery.Topic("", "neuron"); topic != "homeassistant/binary_sensor/neuron/door/config" {
+		t.Errorf("Expected default prefix discovery topic, got %s\n", topic)
+	}
+	if topic := discovery.Topic("ha", "neuron"); topic != "ha/binary_sensor/neuron/door/config" {
+		t.Errorf("Expected custom prefix discovery topic, got %s\n", topic)
+	}
+}
+
+func TestDiscoveryDeviceAvailability(t *testing.T) {
+	c := Configuration{
+		Device:                  "neuron",
+		AvailabilityTopic:       "modbridge/status",
+		DeviceAvailabilityTopic: "modbridge/{device}/status",
+		InputRegisters:          []RegisterConfig{{Slug: "temperature"}},
+	}
+	payload := c.DiscoveryList()[0].Payload
+	expected := []map[string]string{{"topic": "modbridge/status"}, {"topic": "modbridge/neuron/status"}}
+	if !reflect.DeepEqual(payload["availability"], expected) || payload["availability_mode"] != "all" {
+		t.Errorf("Expected both availability topics, got %v\n", payload)
+	}
+	if _, ok := payload["availability_topic"]; ok {
+		t.Errorf("Expected no single availability topic, got %v\n", payload)
+	}
+}
+
+func TestDiscoveryGestures(t *testing.T) {
+	c := Configuration{Device: "neuron", DiscreteInputs: []CoilConfig{{Slug: "button", Publish: Gesture, Payloads: Payloads{Double: "twice"}}}}
+	discoveries := c.DiscoveryList()
+	if len(discoveries) != 4 {
+		t.Fatalf("Expected 4 device triggers, got %v\n", discoveries)
+	}
+	if discoveries[1].ObjectID != "button_double" || discoveries[1].Payload["type"] != "button_double_press" || discoveries[1].Payload["payload"] != "twice" {
+		t.Errorf("Expected a double press trigger, got %v\n", discoveries[1])
+	}
+}
+
+func TestPublishDiscovery(t *testing.T) {
+	c := Configuration{Device: "neuron", InputRegisters: []RegisterConfig{{Slug: "temperature"}}}
+	mqttClient := &mocks.MQTTClient{}
+	mqttClient.On("Publish", "homeassistant/sensor/neuron/temperature/config", byte(1), true, mock.MatchedBy(func(payload []byte) bool {
+		var decoded map[string]interface{}
+		return json.Unmarshal(payload, &decoded) == nil && decoded["state_topic"] == "neuron/temperature"
+	})).Return(&mqtt.PublishToken{})
+	if err := c.PublishDiscovery(mqttClient); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+	mqttClient.AssertExpectations(t)
+}
@@ -0,0 +1,89 @@
+package modbridge
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Default gesture timings
+const (
+	DefaultDoubleClick = 400 * time.Millisecond
+	DefaultLongPress   = 800 * time.Millisecond
+	DefaultHoldRepeat  = 500 * time.Millisecond
+)
+
+// Gestures holds the timings for detecting button gestures, where zero values fall back to the defaults
+type Gestures struct {
+	DoubleClick time.Duration `yaml:"double_click"` // Maximum time between the release of a click and the next press
+	LongPress   time.Duration `yaml:"long_press"`   // Time the button needs to be held for a long press
+	HoldRepeat  time.Duration `yaml:"hold_repeat"`  // Interval of the hold events while the button is held after a long press
+}
+
+// resolve returns the gesture timings, falling back to the defaults for the ones which are not set
+func (gestures Gestures) resolve() Gestures {
+	if gestures.DoubleClick == 0 {
+		gestures.DoubleClick = DefaultDoubleClick
+	}
+	if gestures.LongPress == 0 {
+		gestures.LongPress = DefaultLongPress
+	}
+	if gestures.HoldRepeat == 0 {
+		gestures.HoldRepeat = DefaultHoldRepeat
+	}
+	return gestures
+}
+
+// gesture keeps track of the button gesture in progress for a coil
+type gesture struct {
+	stale      bool // Already held when seeding the coil, so ignored until released
+	clicks     int
+	held       bool
+	pressedAt  time.Time
+	releasedAt time.Time
+	heldAt     time.Time
+}
+
+// updateGesture advances the gesture state machine of a coil after an update, publishing the detected events.
+// A short press is only published as a single click once no second click followed within the double click time.
+// Holding the button publishes a long press once, followed by repeated hold events while it is still held.
+func (coil *Coil) updateGesture(mqttClient mqtt.Client) {
+	timings := coil.gestures.resolve()
+	now := coil.clock()
+	state := &coil.gesture
+	if state.stale {
+		state.stale = coil.active()
+		return
+	}
+	pendingSingle := state.clicks == 1 && now.Sub(state.releasedAt) >= timings.DoubleClick
+	switch {
+	case coil.pressed():
+		if pendingSingle {
+			coil.publishPayload(orDefault(coil.payloads.Single, "single"), mqttClient)
+			state.clicks = 0
+		}
+		state.pressedAt, state.held = now, false
+	case coil.released():
+		if state.held {
+			state.held = false
+			return
+		}
+		state.clicks++
+		state.releasedAt = now
+		if state.clicks == 2 {
+			coil.publishPayload(orDefault(coil.payloads.Double, "double"), mqttClient)
+			state.clicks = 0
+		}
+	case coil.active():
+		if !state.held && now.Sub(state.pressedAt) >= timings.LongPress {
+			coil.publishPayload(orDefault(coil.payloads.Long, "long"), mqttClient)
+			state.held, state.heldAt, state.clicks = true, now, 0
+		} else if state.held && now.Sub(state.heldAt) >= timings.HoldRepeat {
+			coil.publishPayload(orDefault(coil.payloads.Hold, "hold"), mqttClient)
+			state.heldAt = now
+		}
+	case pendingSingle:
+		coil.publishPayload(orDefault(coil.payloads.Single, "single"), mqttClient)
+		state.clicks = 0
+	}
+}
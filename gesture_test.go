@@ -0,0 +1,62 @@
+package modbridge
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mhemeryck/modbridge/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGesturesResolve(t *testing.T) {
+	gestures := Gestures{LongPress: time.Second}.resolve()
+	expected := Gestures{DoubleClick: DefaultDoubleClick, LongPress: time.Second, HoldRepeat: DefaultHoldRepeat}
+	if gestures != expected {
+		t.Errorf("Expected gestures %v, got %v\n", expected, gestures)
+	}
+}
+
+func TestCoilUpdateGesture(t *testing.T) {
+	// Each case lists the times in millis at which the button was pressed or released, polling every 50ms up to 2s after seeding at 0
+	cases := []struct {
+		name     string
+		presses  [][2]int
+		expected []string
+	}{
+		{name: "single", presses: [][2]int{{100, 200}}, expected: []string{"single"}},
+		{name: "double", presses: [][2]int{{100, 200}, {400, 500}}, expected: []string{"double"}},
+		{name: "two singles", presses: [][2]int{{100, 200}, {700, 800}}, expected: []string{"single", "single"}},
+		{name: "long", presses: [][2]int{{100, 1000}}, expected: []string{"long"}},
+		{name: "hold", presses: [][2]int{{100, 1500}}, expected: []string{"long", "hold"}},
+		{name: "click and hold", presses: [][2]int{{100, 200}, {400, 1300}}, expected: []string{"long"}},
+		{name: "held at startup", presses: [][2]int{{0, 1500}}, expected: nil},
+		{name: "held at startup and single", presses: [][2]int{{0, 500}, {700, 800}}, expected: []string{"single"}},
+	}
+	for _, testCase := range cases {
+		var published []string
+		MQTTClient := &mocks.MQTTClient{}
+		MQTTClient.On("Publish", "button", byte(0), false, mock.Anything).Return(&mqtt.PublishToken{}).Run(func(args mock.Arguments) {
+			published = append(published, args.String(3))
+		})
+		start := time.Now()
+		elapsed := 0
+		coil := Coil{Slug: "button", publish: Gesture, now: func() time.Time { return start.Add(time.Duration(elapsed) * time.Millisecond) }}
+		for ; elapsed <= 2000; elapsed += 50 {
+			value := false
+			for _, press := range testCase.presses {
+				value = value || (elapsed >= press[0] && elapsed < press[1])
+			}
+			// The first poll seeds the coil, like it does for a coil group
+			if elapsed == 0 {
+				coil.seed(value, MQTTClient)
+			} else {
+				coil.Update(value, MQTTClient)
+			}
+		}
+		if !reflect.DeepEqual(published, testCase.expected) {
+			t.Errorf("Expected %v for a %s press, got %v\n", testCase.expected, testCase.name, published)
+		}
+	}
+}
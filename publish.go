@@ -0,0 +1,40 @@
+package modbridge
+
+import "fmt"
+
+// QoS is the MQTT quality of service level
+type QoS byte
+
+// UnmarshalYAML rejects quality of service levels other than 0, 1 or 2 when reading in the config
+func (qos *QoS) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value int
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	if value < 0 || value > 2 {
+		return fmt.Errorf("invalid qos %d, should be 0, 1 or 2", value)
+	}
+	*qos = QoS(value)
+	return nil
+}
+
+// PublishConfig holds the MQTT publish settings, either globally or for a single point
+type PublishConfig struct {
+	QoS    *QoS
+	Retain *bool
+}
+
+// resolve returns the publish settings, falling back to the defaults for the ones which are not set
+func (publishConfig PublishConfig) resolve(defaults PublishConfig) (qos byte, retain bool) {
+	if publishConfig.QoS != nil {
+		qos = byte(*publishConfig.QoS)
+	} else if defaults.QoS != nil {
+		qos = byte(*defaults.QoS)
+	}
+	if publishConfig.Retain != nil {
+		retain = *publishConfig.Retain
+	} else if defaults.Retain != nil {
+		retain = *defaults.Retain
+	}
+	return
+}
@@ -0,0 +1,42 @@
+package modbridge
+
+import (
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestPublishConfigResolve(t *testing.T) {
+	one, two := QoS(1), QoS(2)
+	yes, no := true, false
+	cases := []struct {
+		publishConfig  PublishConfig
+		defaults       PublishConfig
+		expectedQoS    byte
+		expectedRetain bool
+	}{
+		{publishConfig: PublishConfig{}, defaults: PublishConfig{}, expectedQoS: 0, expectedRetain: false},
+		{publishConfig: PublishConfig{}, defaults: PublishConfig{QoS: &one, Retain: &yes}, expectedQoS: 1, expectedRetain: true},
+		{publishConfig: PublishConfig{QoS: &two, Retain: &no}, defaults: PublishConfig{QoS: &one, Retain: &yes}, expectedQoS: 2, expectedRetain: false},
+		{publishConfig: PublishConfig{Retain: &yes}, defaults: PublishConfig{QoS: &one}, expectedQoS: 1, expectedRetain: true},
+	}
+	for _, testCase := range cases {
+		qos, retain := testCase.publishConfig.resolve(testCase.defaults)
+		if qos != testCase.expectedQoS || retain != testCase.expectedRetain {
+			t.Errorf("Expected qos %d and retain %v, got %d and %v\n", testCase.expectedQoS, testCase.expectedRetain, qos, retain)
+		}
+	}
+}
+
+func TestQoSUnmarshal(t *testing.T) {
+	var publishConfig PublishConfig
+	if err := yaml.Unmarshal([]byte(`{qos: 2, retain: true}`), &publishConfig); err != nil {
+		t.Errorf("Expected no error, got %v\n", err)
+	}
+	if *publishConfig.QoS != 2 || !*publishConfig.Retain {
+		t.Errorf("Expected qos 2 and retain, got %v\n", publishConfig)
+	}
+	if err := yaml.Unmarshal([]byte(`{qos: 3}`), &publishConfig); err == nil {
+		t.Errorf("Expected an error for an invalid qos\n")
+	}
+}
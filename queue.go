@@ -0,0 +1,155 @@
+package modbridge
+
+import (
+	"github.com/goburrow/modbus"
+)
+
+// queueSize is the number of requests which can be waiting for the modbus client before blocking
+const queueSize = 16
+
+// request is a single call to the modbus client waiting in the queue
+type request struct {
+	call    func(client modbus.Client) ([]byte, error)
+	prepare func()
+	result  chan response
+}
+
+// response holds the outcome of a request
+type response struct {
+	results []byte
+	err     error
+}
+
+// Queue serializes all requests to a modbus client, so polling and MQTT writes never use it at the same time.
+// Writes waiting in the queue are sent before any of the reads.
+type Queue struct {
+	client modbus.Client
+	writes chan request
+	reads  chan request
+
+	// Run right before each request made through this view of the queue, like addressing a slave on a shared bus
+	prepare func()
+}
+
+// NewQueue creates a queue in front of a modbus client and starts handling its requests
+func NewQueue(client modbus.Client) *Queue {
+	queue := &Queue{
+		client: client,
+		writes: make(chan request, queueSize),
+		reads:  make(chan request, queueSize),
+	}
+	go queue.serve()
+	return queue
+}
+
+// withPrepare returns a view of the queue which runs prepare right before sending each of its requests.
+// All of the views share the same client and the same order.
+func (queue *Queue) withPrepare(prepare func()) *Queue {
+	view := *queue
+	view.prepare = prepare
+	return &view
+}
+
+// serve sends the requests to the client one at a time, writes first
+func (queue *Queue) serve() {
+	for {
+		select {
+		case r := <-queue.writes:
+			queue.send(r)
+			continue
+		default:
+		}
+		select {
+		case r := <-queue.writes:
+			queue.send(r)
+		case r := <-queue.reads:
+			queue.send(r)
+		}
+	}
+}
+
+// send calls the client for a request and passes on the response
+func (queue *Queue) send(r request) {
+	if r.prepare != nil {
+		r.prepare()
+	}
+	results, err := r.call(queue.client)
+	r.result <- response{results: results, err: err}
+}
+
+// submit adds a request to the queue and waits for its response
+func (queue *Queue) submit(requests chan request, call func(client modbus.Client) ([]byte, error)) ([]byte, error) {
+	r := request{call: call, prepare: queue.prepare, result: make(chan response, 1)}
+	requests <- r
+	response := <-r.result
+	return response.results, response.err
+}
+
+// read queues a request which doesn't change the device
+func (queue *Queue) read(call func(client modbus.Client) ([]byte, error)) ([]byte, error) {
+	return queue.submit(queue.reads, call)
+}
+
+// write queues a request which changes the device, to be sent before any reads
+func (queue *Queue) write(call func(client modbus.Client) ([]byte, error)) ([]byte, error) {
+	return queue.submit(queue.writes, call)
+}
+
+// ReadCoils queues reading coils
+func (queue *Queue) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return queue.read(func(client modbus.Client) ([]byte, error) { return client.ReadCoils(address, quantity) })
+}
+
+// ReadDiscreteInputs queues reading discrete inputs
+func (queue *Queue) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return queue.read(func(client modbus.Client) ([]byte, error) { return client.ReadDiscreteInputs(address, quantity) })
+}
+
+// WriteSingleCoil queues writing a single coil
+func (queue *Queue) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	return queue.write(func(client modbus.Client) ([]byte, error) { return client.WriteSingleCoil(address, value) })
+}
+
+// WriteMultipleCoils queues writing a sequence of coils
+func (queue *Queue) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	return queue.write(func(client modbus.Client) ([]byte, error) { return client.WriteMultipleCoils(address, quantity, value) })
+}
+
+// ReadInputRegisters queues reading input registers
+func (queue *Queue) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return queue.read(func(client modbus.Client) ([]byte, error) { return client.ReadInputRegisters(address, quantity) })
+}
+
+// ReadHoldingRegisters queues reading holding registers
+func (queue *Queue) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return queue.read(func(client modbus.Client) ([]byte, error) { return client.ReadHoldingRegisters(address, quantity) })
+}
+
+// WriteSingleRegister queues writing a single holding register
+func (queue *Queue) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	return queue.write(func(client modbus.Client) ([]byte, error) { return client.WriteSingleRegister(address, value) })
+}
+
+// WriteMultipleRegisters queues writing a block of holding registers
+func (queue *Queue) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	return queue.write(func(client modbus.Client) ([]byte, error) {
+		return client.WriteMultipleRegisters(address, quantity, value)
+	})
+}
+
+// ReadWriteMultipleRegisters queues a combined write and read of holding registers, handled as a write
+func (queue *Queue) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) ([]byte, error) {
+	return queue.write(func(client modbus.Client) ([]byte, error) {
+		return client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	})
+}
+
+// MaskWriteRegister queues modifying a holding register with a mask
+func (queue *Queue) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	return queue.write(func(client modbus.Client) ([]byte, error) { return client.MaskWriteRegister(address, andMask, orMask) })
+}
+
+// ReadFIFOQueue queues reading a FIFO queue of registers
+func (queue *Queue) ReadFIFOQueue(address uint16) ([]byte, error) {
+	return queue.read(func(client modbus.Client) ([]byte, error) { return client.ReadFIFOQueue(address) })
+}
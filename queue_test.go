@@ -0,0 +1,68 @@
+package modbridge
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mhemeryck/modbridge/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestQueueResults(t *testing.T) {
+	modbusClient := &mocks.ModbusClient{}
+	modbusClient.On("ReadCoils", uint16(1), uint16(2)).Return([]byte{3}, nil)
+	modbusClient.On("WriteSingleRegister", uint16(4), uint16(5)).Return(nil, errors.New("bzzt"))
+	queue := NewQueue(modbusClient)
+
+	if results, err := queue.ReadCoils(1, 2); err != nil || !reflect.DeepEqual(results, []byte{3}) {
+		t.Errorf("Expected the results of the client, got %v, %v\n", results, err)
+	}
+	if _, err := queue.WriteSingleRegister(4, 5); err == nil || err.Error() != "bzzt" {
+		t.Errorf("Expected the error of the client, got %v\n", err)
+	}
+	modbusClient.AssertExpectations(t)
+}
+
+func TestQueueWritesFirst(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, args.String(0))
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	modbusClient := &mocks.ModbusClient{}
+	modbusClient.On("ReadCoils", uint16(0), uint16(1)).Run(func(args mock.Arguments) {
+		close(started)
+		<-release
+	}).Return([]byte{0}, nil)
+	modbusClient.On("ReadHoldingRegisters", uint16(0), uint16(1)).Run(func(args mock.Arguments) {
+		record(mock.Arguments{"read"})
+	}).Return([]byte{0, 0}, nil)
+	modbusClient.On("WriteSingleCoil", uint16(0), uint16(0xFF00)).Run(func(args mock.Arguments) {
+		record(mock.Arguments{"write"})
+	}).Return([]byte{}, nil)
+	queue := NewQueue(modbusClient)
+
+	// Block the client on a first read, while a read and a write are queued
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() { defer wg.Done(); queue.ReadCoils(0, 1) }()
+	<-started
+	go func() { defer wg.Done(); queue.ReadHoldingRegisters(0, 1) }()
+	go func() { defer wg.Done(); queue.WriteSingleCoil(0, 0xFF00) }()
+	for len(queue.reads) != 1 || len(queue.writes) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if !reflect.DeepEqual(order, []string{"write", "read"}) {
+		t.Errorf("Expected the write to be sent before the read, got %v\n", order)
+	}
+}
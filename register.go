@@ -0,0 +1,167 @@
+package modbridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/goburrow/modbus"
+)
+
+// Register represents the state we keep about a value spanning one or more modbus registers
+type Register struct {
+	Address   uint16
+	Slug      string
+	dataType  DataType
+	wordOrder Order
+	byteOrder Order
+	scale     float64
+	offset    float64
+	precision *int
+	unit      string
+	qos       byte
+	retain    bool
+	topic     string
+	raw       []byte
+}
+
+// Size returns the number of registers spanned by the register value
+func (register *Register) Size() uint16 {
+	return register.dataType.Size()
+}
+
+// factor returns the scale of the register, where an unset scale means no scaling
+func (register *Register) factor() float64 {
+	if register.scale == 0 {
+		return 1
+	}
+	return register.scale
+}
+
+// transformed indicates whether the raw value needs any conversion to obtain the engineering value
+func (register *Register) transformed() bool {
+	return register.factor() != 1 || register.offset != 0 || register.precision != nil
+}
+
+// numberRange returns the range of engineering values which can be written to the register, along with the smallest step between them
+func (register *Register) numberRange() (min float64, max float64, step float64) {
+	min, limit := register.dataType.bounds()
+	max = limit
+	if !register.dataType.float() {
+		max = limit - 1
+	}
+	// Large floats may overflow once scaled, so clamp them back
+	clamp := func(value float64) float64 {
+		return math.Max(-math.MaxFloat64, math.Min(math.MaxFloat64, value))
+	}
+	min = clamp(min*register.factor() + register.offset)
+	max = clamp(max*register.factor() + register.offset)
+	if min > max {
+		min, max = max, min
+	}
+	// Integers change by the scale, floats by the precision
+	step = math.Abs(register.factor())
+	if register.dataType.float() {
+		step = 0.001
+	}
+	if register.precision != nil {
+		step = math.Max(step, math.Pow(10, -float64(*register.precision)))
+	}
+	return
+}
+
+// format converts the raw register data into the engineering value to publish
+func (register *Register) format(data []byte) string {
+	normalized := normalize(data, register.wordOrder, register.byteOrder)
+	if !register.transformed() {
+		return register.dataType.format(normalized)
+	}
+	value := register.dataType.value(normalized)*register.factor() + register.offset
+	if register.precision != nil {
+		return strconv.FormatFloat(value, 'f', *register.precision, 64)
+	}
+	// Without a precision, floats are formatted at their own width, integers with as many decimals as the scale and offset
+	switch register.dataType {
+	case Float32:
+		return strconv.FormatFloat(value, 'f', -1, 32)
+	case Float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	precision := decimals(register.factor())
+	if offset := decimals(register.offset); offset > precision {
+		precision = offset
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// decimals returns the number of decimals needed to write a number like 0.25
+func decimals(value float64) int {
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if dot := strings.IndexByte(text, '.'); dot >= 0 {
+		return len(text) - dot - 1
+	}
+	return 0
+}
+
+// encode applies the inverse transform to an engineering value and converts it into the raw register data
+func (register *Register) encode(payload string) ([]byte, error) {
+	text := strings.TrimSpace(payload)
+	// Whole numbers are written exactly to integer registers without any transform, as a float64 can't hold all of the 64 bit values
+	if !register.transformed() && !register.dataType.float() {
+		data, err := register.dataType.encodeInteger(text)
+		if numError, ok := err.(*strconv.NumError); !ok || numError.Err != strconv.ErrSyntax {
+			if err != nil {
+				return nil, err
+			}
+			return normalize(data, register.wordOrder, register.byteOrder), nil
+		}
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, err
+	}
+	raw := (value - register.offset) / register.factor()
+	if !register.dataType.float() && math.Abs(math.Round(raw)) > 1<<53 {
+		return nil, fmt.Errorf("value %s can't be written exactly to %s", text, register.dataType)
+	}
+	data, err := register.dataType.encode(raw)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(data, register.wordOrder, register.byteOrder), nil
+}
+
+// Update publishes a new value on the state topic in case the raw data differs from the retained state we have for a register
+func (register *Register) Update(data []byte, mqttClient mqtt.Client) {
+	if register.raw != nil && bytes.Equal(register.raw, data) {
+		return
+	}
+	register.raw = append([]byte{}, data...)
+	register.publishPayload(register.format(data), mqttClient)
+}
+
+// publishPayload logs and publishes a value on the state topic of the register, which defaults to its slug
+func (register *Register) publishPayload(payload string, mqttClient mqtt.Client) {
+	log.Printf("%s  -  value %s%s for %s", time.Now().Format(time.RFC3339), payload, register.unit, register.Slug)
+	mqttClient.Publish(orDefault(register.topic, register.Slug), register.qos, register.retain, payload)
+}
+
+// Write converts an engineering value payload and writes it to the holding register(s)
+func (register *Register) Write(payload string, modbusClient modbus.Client) (err error) {
+	data, err := register.encode(payload)
+	if err != nil {
+		return
+	}
+	if register.Size() == 1 {
+		_, err = modbusClient.WriteSingleRegister(register.Address, binary.BigEndian.Uint16(data))
+	} else {
+		_, err = modbusClient.WriteMultipleRegisters(register.Address, register.Size(), data)
+	}
+	return
+}
@@ -0,0 +1,135 @@
+package modbridge
+
+import (
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mhemeryck/modbridge/mocks"
+)
+
+func TestRegisterUpdate(t *testing.T) {
+	table := []struct {
+		data          []byte
+		initialRaw    []byte
+		dataType      DataType
+		wordOrder     Order
+		shouldPublish bool
+		payload       string
+	}{
+		// First value is always published
+		{data: []byte{0x00, 0x00}, initialRaw: nil, shouldPublish: true, payload: "0"},
+		// Changed value is published
+		{data: []byte{0x09, 0x0A}, initialRaw: []byte{0x09, 0x09}, shouldPublish: true, payload: "2314"},
+		// Unchanged value is not published
+		{data: []byte{0x00, 0x0C}, initialRaw: []byte{0x00, 0x0C}, shouldPublish: false},
+		// Signed value
+		{data: []byte{0xFF, 0xFE}, dataType: Int16, shouldPublish: true, payload: "-2"},
+		// Swapped-word float32
+		{data: []byte{0x00, 0x00, 0x43, 0x67}, dataType: Float32, wordOrder: LittleEndian, shouldPublish: true, payload: "231"},
+	}
+	for _, testCase := range table {
+		register := Register{Slug: "test", raw: testCase.initialRaw, dataType: testCase.dataType, wordOrder: testCase.wordOrder}
+		mqttClient := &mocks.MQTTClient{}
+		if testCase.shouldPublish {
+			mqttClient.On("Publish", "test", byte(0), false, testCase.payload).Return(&mqtt.PublishToken{})
+		}
+		register.Update(testCase.data, mqttClient)
+		mqttClient.AssertExpectations(t)
+		if string(register.raw) != string(testCase.data) {
+			t.Errorf("Expected retained data %v but got %v\n", testCase.data, register.raw)
+		}
+	}
+}
+
+func TestRegisterSize(t *testing.T) {
+	cases := []struct {
+		dataType DataType
+		expected uint16
+	}{
+		{dataType: "", expected: 1},
+		{dataType: Int16, expected: 1},
+		{dataType: Uint32, expected: 2},
+		{dataType: Float32, expected: 2},
+		{dataType: Int64, expected: 4},
+		{dataType: Float64, expected: 4},
+	}
+	for _, testCase := range cases {
+		register := Register{dataType: testCase.dataType}
+		if register.Size() != testCase.expected {
+			t.Errorf("Expected size %d for %q, got %d\n", testCase.expected, testCase.dataType, register.Size())
+		}
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	zero, one := 0, 1
+	cases := []struct {
+		register Register
+		data     []byte
+		expected string
+	}{
+		{register: Register{scale: 0.1, precision: &one}, data: []byte{0x09, 0x0A}, expected: "231.4"},
+		{register: Register{scale: 0.5, offset: -40}, data: []byte{0x00, 0x51}, expected: "0.5"},
+		{register: Register{dataType: Int16, precision: &zero}, data: []byte{0xFF, 0xFE}, expected: "-2"},
+		{register: Register{}, data: []byte{0x09, 0x0A}, expected: "2314"},
+		{register: Register{scale: 0.1}, data: []byte{0x09, 0x0D}, expected: "231.7"},
+		{register: Register{scale: 0.1}, data: []byte{0x00, 0x03}, expected: "0.3"},
+		{register: Register{scale: 0.25, offset: 1.5}, data: []byte{0x00, 0x03}, expected: "2.25"},
+		{register: Register{scale: 10}, data: []byte{0x00, 0x03}, expected: "30"},
+		{register: Register{dataType: Float32, scale: 0.1}, data: []byte{0x45, 0x10, 0xD0, 0x00}, expected: "231.7"},
+	}
+	for _, testCase := range cases {
+		if actual := testCase.register.format(testCase.data); actual != testCase.expected {
+			t.Errorf("Expected %s, got %s\n", testCase.expected, actual)
+		}
+	}
+}
+
+func TestRegisterWrite(t *testing.T) {
+	cases := []struct {
+		register Register
+		payload  string
+		method   string
+		args     []interface{}
+		err      bool
+	}{
+		// Inverse scaling
+		{register: Register{Address: 3, scale: 0.1}, payload: "231.4", method: "WriteSingleRegister", args: []interface{}{uint16(3), uint16(2314)}},
+		// Inverse offset on a signed value
+		{register: Register{Address: 3, dataType: Int16, offset: 10}, payload: "8", method: "WriteSingleRegister", args: []interface{}{uint16(3), uint16(0xFFFE)}},
+		// Multi-register value, swapped words
+		{
+			register: Register{Address: 4, dataType: Uint32, wordOrder: LittleEndian},
+			payload:  "65538",
+			method:   "WriteMultipleRegisters",
+			args:     []interface{}{uint16(4), uint16(2), []byte{0x00, 0x02, 0x00, 0x01}},
+		},
+		// Large 64 bit values are written exactly
+		{
+			register: Register{Address: 4, dataType: Int64},
+			payload:  "9007199254740993",
+			method:   "WriteMultipleRegisters",
+			args:     []interface{}{uint16(4), uint16(4), []byte{0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		},
+		// Whole numbers written with decimals
+		{register: Register{Address: 3}, payload: "21.0", method: "WriteSingleRegister", args: []interface{}{uint16(3), uint16(21)}},
+		// Too large to be scaled exactly
+		{register: Register{Address: 4, dataType: Int64, scale: 0.5}, payload: "9007199254740993", err: true},
+		{register: Register{Address: 4, dataType: Int64}, payload: "9223372036854775808", err: true},
+		// Out of range
+		{register: Register{Address: 3}, payload: "-1", err: true},
+		// Not a number
+		{register: Register{Address: 3}, payload: "ON", err: true},
+	}
+	for _, testCase := range cases {
+		modbusClient := &mocks.ModbusClient{}
+		if testCase.method != "" {
+			modbusClient.On(testCase.method, testCase.args...).Return([]byte{}, nil)
+		}
+		err := testCase.register.Write(testCase.payload, modbusClient)
+		if (err != nil) != testCase.err {
+			t.Errorf("Expected error %v for %s, got %v\n", testCase.err, testCase.payload, err)
+		}
+		modbusClient.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,103 @@
+package modbridge
+
+import (
+	"fmt"
+	"sort"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/goburrow/modbus"
+)
+
+// RegisterGroup represents an array of registers from the same table which have contiguous Addresses.
+// Registers spanning multiple addresses are always read as one unit within the same group.
+type RegisterGroup struct {
+	offset       uint16
+	registers    []Register
+	table        Table
+	ModbusClient modbus.Client
+	MQTTClient   mqtt.Client
+}
+
+// read calls the modbus function matching the table of the group
+func (registerGroup *RegisterGroup) read(quantity uint16) ([]byte, error) {
+	if registerGroup.table == InputRegisterTable {
+		return registerGroup.ModbusClient.ReadInputRegisters(registerGroup.offset, quantity)
+	}
+	return registerGroup.ModbusClient.ReadHoldingRegisters(registerGroup.offset, quantity)
+}
+
+// end returns the address right after the last register spanned by the group
+func (registerGroup *RegisterGroup) end() (end int) {
+	for k := range registerGroup.registers {
+		if registerEnd := int(registerGroup.registers[k].Address) + int(registerGroup.registers[k].Size()); registerEnd > end {
+			end = registerEnd
+		}
+	}
+	return
+}
+
+// Update call the modbus group range and update the corresponding registers
+func (registerGroup *RegisterGroup) Update() (err error) {
+	results, err := registerGroup.read(uint16(registerGroup.end() - int(registerGroup.offset)))
+	if err != nil {
+		return
+	}
+	for k := range registerGroup.registers {
+		register := &registerGroup.registers[k]
+		start := 2 * int(register.Address-registerGroup.offset)
+		stop := start + 2*int(register.Size())
+		if stop > len(results) {
+			break
+		}
+		register.Update(results[start:stop], registerGroup.MQTTClient)
+	}
+	return
+}
+
+// String describes the block of addresses read for the group
+func (registerGroup *RegisterGroup) String() string {
+	used := make(map[int]bool)
+	for k := range registerGroup.registers {
+		start := int(registerGroup.registers[k].Address)
+		for address := start; address < start+int(registerGroup.registers[k].Size()); address++ {
+			used[address] = true
+		}
+	}
+	end := registerGroup.end()
+	unused := end - int(registerGroup.offset) - len(used)
+	return fmt.Sprintf("%s %d-%d (%d unused)", registerGroup.table, registerGroup.offset, end-1, unused)
+}
+
+// ByRegisterAddress implements sorter interface, for sorting an array of registers based on Address
+type ByRegisterAddress []Register
+
+func (regs ByRegisterAddress) Len() int           { return len(regs) }
+func (regs ByRegisterAddress) Swap(i, j int)      { regs[i], regs[j] = regs[j], regs[i] }
+func (regs ByRegisterAddress) Less(i, j int) bool { return regs[i].Address < regs[j].Address }
+
+// MaxReadRegisters is the maximum number of registers the modbus protocol allows to read at once
+const MaxReadRegisters = 125
+
+// GroupRegisters groups an array of registers from one table into an array of register groups
+func GroupRegisters(registers []Register, table Table) []RegisterGroup {
+	return groupRegisters(registers, table, MaxReadRegisters, 0)
+}
+
+// groupRegisters groups an array of registers from one table into groups spanning at most maxSize addresses.
+// Holes of up to maxGap unmapped addresses are read across, dropping their data on updates.
+func groupRegisters(registers []Register, table Table, maxSize int, maxGap int) (groups []RegisterGroup) {
+	// Sort inputs by Address first
+	sort.Sort(ByRegisterAddress(registers))
+
+	for _, register := range registers {
+		groupIndex := len(groups) - 1
+		// Compare the current Address against the end of the current group, keeping overlapping values together
+		if groupIndex >= 0 && int(register.Address) <= groups[groupIndex].end()+maxGap &&
+			int(register.Address)+int(register.Size())-int(groups[groupIndex].offset) <= maxSize {
+			groups[groupIndex].registers = append(groups[groupIndex].registers, register)
+		} else {
+			groups = append(groups, RegisterGroup{offset: register.Address, registers: []Register{register}, table: table})
+		}
+	}
+	return
+}
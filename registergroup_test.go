@@ -0,0 +1,128 @@
+package modbridge
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mhemeryck/modbridge/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRegisterGroupUpdate(t *testing.T) {
+	cases := []struct {
+		table    Table
+		method   string
+		results  []byte
+		expected [][]byte
+		err      error
+	}{
+		{table: HoldingRegisterTable, method: "ReadHoldingRegisters", results: []byte{0x09, 0x0A, 0x00, 0x01}, expected: [][]byte{{0x09, 0x0A}, {0x00, 0x01}}},
+		{table: InputRegisterTable, method: "ReadInputRegisters", results: []byte{0x00, 0x10, 0xFF, 0xFF}, expected: [][]byte{{0x00, 0x10}, {0xFF, 0xFF}}},
+		{table: InputRegisterTable, method: "ReadInputRegisters", err: errors.New("bzzt"), expected: [][]byte{nil, nil}},
+	}
+	for _, testCase := range cases {
+		registers := []Register{{Address: 20, Slug: "a"}, {Address: 21, Slug: "b"}}
+		ModbusClient := &mocks.ModbusClient{}
+		MQTTClient := &mocks.MQTTClient{}
+		registerGroup := &RegisterGroup{offset: 20, registers: registers, table: testCase.table, ModbusClient: ModbusClient, MQTTClient: MQTTClient}
+		ModbusClient.On(testCase.method, uint16(20), uint16(2)).Return(testCase.results, testCase.err)
+		MQTTClient.On("Publish", mock.AnythingOfType("string"), byte(0), false, mock.AnythingOfType("string")).Return(&mqtt.PublishToken{})
+
+		resultErr := registerGroup.Update()
+		if resultErr != testCase.err {
+			t.Errorf("Expected error %v but got %v\n", testCase.err, resultErr)
+		}
+		ModbusClient.AssertExpectations(t)
+		for k, expected := range testCase.expected {
+			if !reflect.DeepEqual(registerGroup.registers[k].raw, expected) {
+				t.Errorf("Expected raw data %v but got %v\n", expected, registerGroup.registers[k].raw)
+			}
+		}
+	}
+}
+
+func TestGroupRegisters(t *testing.T) {
+	input := []Register{{Address: 5}, {Address: 100}, {Address: 4}}
+	expected := []RegisterGroup{
+		{offset: 4, registers: []Register{{Address: 4}, {Address: 5}}, table: InputRegisterTable},
+		{offset: 100, registers: []Register{{Address: 100}}, table: InputRegisterTable},
+	}
+	actual := GroupRegisters(input, InputRegisterTable)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Error grouping registers: expected %v, got %v\n", expected, actual)
+	}
+}
+
+func TestRegisterGroupUpdateMultiRegister(t *testing.T) {
+	registers := []Register{{Address: 0, Slug: "voltage", dataType: Float32}, {Address: 2, Slug: "counter", dataType: Uint32}}
+	ModbusClient := &mocks.ModbusClient{}
+	MQTTClient := &mocks.MQTTClient{}
+	registerGroup := &RegisterGroup{offset: 0, registers: registers, table: InputRegisterTable, ModbusClient: ModbusClient, MQTTClient: MQTTClient}
+	ModbusClient.On("ReadInputRegisters", uint16(0), uint16(4)).Return([]byte{0x43, 0x67, 0x66, 0x66, 0x00, 0x01, 0x00, 0x02}, nil)
+	MQTTClient.On("Publish", "voltage", byte(0), false, "231.4").Return(&mqtt.PublishToken{})
+	MQTTClient.On("Publish", "counter", byte(0), false, "65538").Return(&mqtt.PublishToken{})
+
+	if err := registerGroup.Update(); err != nil {
+		t.Errorf("Expected no error but got %v\n", err)
+	}
+	ModbusClient.AssertExpectations(t)
+	MQTTClient.AssertExpectations(t)
+}
+
+func TestGroupRegistersMultiRegister(t *testing.T) {
+	// A float32 at 0 spans 0-1, so 2 is contiguous but 1 would never start a new group
+	input := []Register{{Address: 4}, {Address: 0, dataType: Float32}, {Address: 2, dataType: Int64}, {Address: 7}}
+	actual := GroupRegisters(input, HoldingRegisterTable)
+	if len(actual) != 2 {
+		t.Fatalf("Expected 2 groups, got %v\n", actual)
+	}
+	if actual[0].offset != 0 || len(actual[0].registers) != 3 || actual[0].end() != 6 {
+		t.Errorf("Expected first group to span 0-5, got %v\n", actual[0])
+	}
+	if actual[1].offset != 7 || actual[1].end() != 8 {
+		t.Errorf("Expected second group to span 7, got %v\n", actual[1])
+	}
+}
+
+func TestGroupRegistersLimits(t *testing.T) {
+	var input []Register
+	for address := 0; address < MaxReadRegisters+1; address++ {
+		input = append(input, Register{Address: uint16(address)})
+	}
+	groups := GroupRegisters(input, InputRegisterTable)
+	if len(groups) != 2 || len(groups[0].registers) != MaxReadRegisters || groups[1].offset != MaxReadRegisters {
+		t.Errorf("Expected groups to be split at %d registers, got %d groups\n", MaxReadRegisters, len(groups))
+	}
+
+	// Multi-register values move to the next group as a whole
+	groups = groupRegisters([]Register{{Address: 0}, {Address: 1}, {Address: 2, dataType: Float32}}, HoldingRegisterTable, 3, 0)
+	if len(groups) != 2 || groups[0].end() != 2 || groups[1].offset != 2 || groups[1].end() != 4 {
+		t.Errorf("Expected the float to start a new group, got %v\n", groups)
+	}
+}
+
+func TestGroupRegistersGaps(t *testing.T) {
+	input := []Register{{Address: 0}, {Address: 3, dataType: Uint32}, {Address: 10}}
+	groups := groupRegisters(input, InputRegisterTable, MaxReadRegisters, 2)
+	if len(groups) != 2 || len(groups[0].registers) != 2 || groups[1].offset != 10 {
+		t.Fatalf("Expected the gap of 2 registers to be read across, got %v\n", groups)
+	}
+	if description := groups[0].String(); description != "input registers 0-4 (2 unused)" {
+		t.Errorf("Expected description of the read block, got %s\n", description)
+	}
+
+	ModbusClient := &mocks.ModbusClient{}
+	MQTTClient := &mocks.MQTTClient{}
+	groups[0].registers[0].Slug, groups[0].registers[1].Slug = "first", "second"
+	groups[0].ModbusClient, groups[0].MQTTClient = ModbusClient, MQTTClient
+	ModbusClient.On("ReadInputRegisters", uint16(0), uint16(5)).Return([]byte{0, 1, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 2}, nil)
+	MQTTClient.On("Publish", "first", byte(0), false, "1").Return(&mqtt.PublishToken{})
+	MQTTClient.On("Publish", "second", byte(0), false, "2").Return(&mqtt.PublishToken{})
+	if err := groups[0].Update(); err != nil {
+		t.Errorf("Expected no error but got %v\n", err)
+	}
+	ModbusClient.AssertExpectations(t)
+	MQTTClient.AssertExpectations(t)
+}
@@ -0,0 +1,98 @@
+package modbridge
+
+import (
+	"sort"
+	"time"
+)
+
+// Schedule holds the groups of points which are polled at the same interval.
+// Groups without an interval are polled in turn, one group per tick of the base polling interval.
+type Schedule struct {
+	Interval       time.Duration
+	CoilGroups     []CoilGroup
+	RegisterGroups []RegisterGroup
+}
+
+// Groups returns all of the coil and register groups of the schedule
+func (schedule *Schedule) Groups() (groups []GroupUpdater) {
+	for k := range schedule.CoilGroups {
+		groups = append(groups, &schedule.CoilGroups[k])
+	}
+	for k := range schedule.RegisterGroups {
+		groups = append(groups, &schedule.RegisterGroups[k])
+	}
+	return
+}
+
+// pollInterval returns the interval of a point, falling back to the interval of the device
+func (c *Configuration) pollInterval(interval time.Duration) time.Duration {
+	if interval != 0 {
+		return interval
+	}
+	return c.PollInterval
+}
+
+// pollIntervals lists the distinct intervals of all points, in increasing order
+func (c *Configuration) pollIntervals() (intervals []time.Duration) {
+	seen := make(map[time.Duration]bool)
+	add := func(interval time.Duration) {
+		interval = c.pollInterval(interval)
+		if !seen[interval] {
+			seen[interval] = true
+			intervals = append(intervals, interval)
+		}
+	}
+	for _, coilConfigs := range [][]CoilConfig{c.Coils, c.DiscreteInputs} {
+		for _, coilConfig := range coilConfigs {
+			add(coilConfig.PollInterval)
+		}
+	}
+	for _, registerConfigs := range [][]RegisterConfig{c.HoldingRegisters, c.InputRegisters} {
+		for _, registerConfig := range registerConfigs {
+			add(registerConfig.PollInterval)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return
+}
+
+// filterCoilInterval selects the coil configs polled at a given interval
+func (c *Configuration) filterCoilInterval(coilConfigs []CoilConfig, interval time.Duration) (filtered []CoilConfig) {
+	for _, coilConfig := range coilConfigs {
+		if c.pollInterval(coilConfig.PollInterval) == interval {
+			filtered = append(filtered, coilConfig)
+		}
+	}
+	return
+}
+
+// filterRegisterInterval selects the register configs polled at a given interval
+func (c *Configuration) filterRegisterInterval(registerConfigs []RegisterConfig, interval time.Duration) (filtered []RegisterConfig) {
+	for _, registerConfig := range registerConfigs {
+		if c.pollInterval(registerConfig.PollInterval) == interval {
+			filtered = append(filtered, registerConfig)
+		}
+	}
+	return
+}
+
+// Schedules groups the points sharing a polling interval, so groups only ever contain points of the same cadence
+func (c *Configuration) Schedules() (schedules []Schedule) {
+	for _, interval := range c.pollIntervals() {
+		view := *c
+		view.Coils = c.filterCoilInterval(c.Coils, interval)
+		view.DiscreteInputs = c.filterCoilInterval(c.DiscreteInputs, interval)
+		view.HoldingRegisters = c.filterRegisterInterval(c.HoldingRegisters, interval)
+		view.InputRegisters = c.filterRegisterInterval(c.InputRegisters, interval)
+		schedule := Schedule{
+			Interval:       interval,
+			CoilGroups:     view.CoilGroupsList(),
+			RegisterGroups: view.RegisterGroupsList(),
+		}
+		// Skip schedules for which only write-only points were left
+		if len(schedule.CoilGroups)+len(schedule.RegisterGroups) > 0 {
+			schedules = append(schedules, schedule)
+		}
+	}
+	return
+}
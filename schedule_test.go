@@ -0,0 +1,68 @@
+package modbridge
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSchedules(t *testing.T) {
+	c := Configuration{
+		PollInterval: time.Second,
+		Coils: []CoilConfig{
+			{Address: 0, Mode: Read, Slug: "button-1"},
+			{Address: 1, Mode: Read, Slug: "button-2", PollInterval: time.Minute},
+			{Address: 2, Mode: Read, Slug: "button-3"},
+			{Address: 3, Mode: Write, Slug: "relay", PollInterval: time.Hour},
+		},
+		HoldingRegisters: []RegisterConfig{{Address: 1000, Mode: Read, Slug: "setting", PollInterval: time.Minute}},
+	}
+	schedules := c.Schedules()
+	if len(schedules) != 2 {
+		t.Fatalf("Expected 2 schedules, got %d\n", len(schedules))
+	}
+	if schedules[0].Interval != time.Second || len(schedules[0].CoilGroups) != 2 || len(schedules[0].RegisterGroups) != 0 {
+		t.Errorf("Expected the buttons without an interval to be split around the slow one, got %v\n", schedules[0])
+	}
+	if schedules[1].Interval != time.Minute || len(schedules[1].CoilGroups) != 1 || len(schedules[1].RegisterGroups) != 1 {
+		t.Errorf("Expected the slow button and setting to be polled every minute, got %v\n", schedules[1])
+	}
+	if groups := schedules[1].Groups(); len(groups) != 2 {
+		t.Errorf("Expected 2 groups for the slow schedule, got %d\n", len(groups))
+	}
+}
+
+func TestPollIntervalConfiguration(t *testing.T) {
+	var c Configuration
+	data := `
+poll_interval: "1s"
+devices:
+- name: "fast"
+  coils:
+  - address: 0
+    mode: "R"
+    slug: "button"
+- name: "slow"
+  poll_interval: "5m"
+  input_registers:
+  - address: 0
+    mode: "R"
+    slug: "temperature"
+  - address: 1
+    mode: "R"
+    slug: "humidity"
+    poll_interval: "10m"
+`
+	if err := yaml.Unmarshal([]byte(data), &c); err != nil {
+		t.Fatal(err)
+	}
+	devices := c.DevicesList()
+	if devices[0].PollInterval != time.Second || devices[1].PollInterval != 5*time.Minute {
+		t.Errorf("Expected the device poll intervals to override the global one, got %s and %s\n", devices[0].PollInterval, devices[1].PollInterval)
+	}
+	schedules := devices[1].Schedules()
+	if len(schedules) != 2 || schedules[0].Interval != 5*time.Minute || schedules[1].Interval != 10*time.Minute {
+		t.Errorf("Expected schedules of 5 and 10 minutes, got %v\n", schedules)
+	}
+}
@@ -0,0 +1,95 @@
+package modbridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Snapshot keeps the last known values of the coils by their state topic, persisted to a file.
+// On startup, coils are compared against the snapshot, so changes which happened while the bridge was down are published.
+type Snapshot struct {
+	path   string
+	mu     sync.Mutex
+	values map[string]bool
+	dirty  bool
+}
+
+// LoadSnapshot reads in the snapshot file, starting from an empty snapshot in case it doesn't exist yet
+func LoadSnapshot(path string) (*Snapshot, error) {
+	snapshot := &Snapshot{path: path, values: make(map[string]bool)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &snapshot.values); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// get returns the value in the snapshot for a coil, if any
+func (snapshot *Snapshot) get(key string) (value bool, ok bool) {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+	value, ok = snapshot.values[key]
+	return
+}
+
+// set keeps the latest value of a coil
+func (snapshot *Snapshot) set(key string, value bool) {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+	if previous, ok := snapshot.values[key]; !ok || previous != value {
+		snapshot.values[key] = value
+		snapshot.dirty = true
+	}
+}
+
+// Save writes the snapshot to its file in case anything changed, replacing the previous file in one go
+func (snapshot *Snapshot) Save() error {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+	if !snapshot.dirty {
+		return nil
+	}
+	data, err := json.Marshal(snapshot.values)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(snapshot.path), filepath.Base(snapshot.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), snapshot.path); err != nil {
+		return err
+	}
+	snapshot.dirty = false
+	return nil
+}
+
+// SaveEvery saves the snapshot at a regular interval, logging any errors
+func (snapshot *Snapshot) SaveEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := snapshot.Save(); err != nil {
+			log.Printf("Error %s saving snapshot %s", err, snapshot.path)
+		}
+	}
+}
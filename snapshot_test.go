@@ -0,0 +1,49 @@
+package modbridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+
+	// A missing file starts out empty
+	snapshot, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("Expected no error for a missing snapshot, got %v\n", err)
+	}
+	if _, ok := snapshot.get("button"); ok {
+		t.Errorf("Expected an empty snapshot\n")
+	}
+	snapshot.set("button", true)
+	snapshot.set("door", false)
+	if err := snapshot.Save(); err != nil {
+		t.Fatalf("Expected no error saving, got %v\n", err)
+	}
+
+	loaded, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("Expected no error loading, got %v\n", err)
+	}
+	if value, ok := loaded.get("button"); !ok || !value {
+		t.Errorf("Expected the button to be on in the snapshot\n")
+	}
+	if value, ok := loaded.get("door"); !ok || value {
+		t.Errorf("Expected the door to be off in the snapshot\n")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSnapshot(path); err == nil {
+		t.Errorf("Expected an error for an invalid snapshot\n")
+	}
+}
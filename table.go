@@ -0,0 +1,29 @@
+package modbridge
+
+import "fmt"
+
+// Table indicates which of the modbus data tables a point lives in
+type Table int
+
+// Modbus table constants
+const (
+	CoilTable            Table = iota // Coils, function code 1
+	DiscreteInputTable                // Discrete inputs, function code 2
+	InputRegisterTable                // Input registers, function code 4
+	HoldingRegisterTable              // Holding registers, function code 3
+)
+
+// String returns the name of the table, as used for logging
+func (table Table) String() string {
+	switch table {
+	case CoilTable:
+		return "coils"
+	case DiscreteInputTable:
+		return "discrete inputs"
+	case InputRegisterTable:
+		return "input registers"
+	case HoldingRegisterTable:
+		return "holding registers"
+	}
+	return fmt.Sprintf("table %d", int(table))
+}
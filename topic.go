@@ -0,0 +1,43 @@
+package modbridge
+
+import "strings"
+
+// Default topic templates, which boil down to the plain slug for the state when no prefix or device is set
+const (
+	DefaultStateTopic   = "{prefix}/{device}/{slug}"
+	DefaultCommandTopic = "{prefix}/{device}/{slug}/set"
+	DefaultErrorTopic   = "{prefix}/{device}/{slug}/error"
+)
+
+// TopicConfig holds the templates for the state, command and error topics, either globally or for a single point
+type TopicConfig struct {
+	StateTopic   string `yaml:"state_topic"`
+	CommandTopic string `yaml:"command_topic"`
+	ErrorTopic   string `yaml:"error_topic"`
+}
+
+// resolve returns the topic templates, falling back to the defaults for the ones which are not set
+func (topicConfig TopicConfig) resolve(defaults TopicConfig) TopicConfig {
+	if topicConfig.StateTopic == "" {
+		topicConfig.StateTopic = orDefault(defaults.StateTopic, DefaultStateTopic)
+	}
+	if topicConfig.CommandTopic == "" {
+		topicConfig.CommandTopic = orDefault(defaults.CommandTopic, DefaultCommandTopic)
+	}
+	if topicConfig.ErrorTopic == "" {
+		topicConfig.ErrorTopic = orDefault(defaults.ErrorTopic, DefaultErrorTopic)
+	}
+	return topicConfig
+}
+
+// renderTopic fills in the placeholders of a topic template, dropping the levels which end up empty
+func renderTopic(template string, prefix string, device string, slug string) string {
+	replacer := strings.NewReplacer("{prefix}", prefix, "{device}", device, "{slug}", slug)
+	var levels []string
+	for _, level := range strings.Split(replacer.Replace(template), "/") {
+		if level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return strings.Join(levels, "/")
+}
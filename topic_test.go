@@ -0,0 +1,37 @@
+package modbridge
+
+import "testing"
+
+func TestRenderTopic(t *testing.T) {
+	cases := []struct {
+		template string
+		prefix   string
+		device   string
+		slug     string
+		expected string
+	}{
+		{template: DefaultStateTopic, prefix: "", device: "", slug: "relay-1", expected: "relay-1"},
+		{template: DefaultCommandTopic, prefix: "", device: "", slug: "relay-1", expected: "relay-1/set"},
+		{template: DefaultStateTopic, prefix: "modbridge", device: "neuron", slug: "relay-1", expected: "modbridge/neuron/relay-1"},
+		{template: "{prefix}/{device}/{slug}/state", prefix: "home", device: "", slug: "relay-1", expected: "home/relay-1/state"},
+		{template: "{prefix}/cmd/{slug}", prefix: "home", device: "neuron", slug: "relay-1", expected: "home/cmd/relay-1"},
+	}
+	for _, testCase := range cases {
+		if actual := renderTopic(testCase.template, testCase.prefix, testCase.device, testCase.slug); actual != testCase.expected {
+			t.Errorf("Expected topic %s, got %s\n", testCase.expected, actual)
+		}
+	}
+}
+
+func TestTopicConfigResolve(t *testing.T) {
+	point := TopicConfig{StateTopic: "{slug}/status"}
+	defaults := TopicConfig{CommandTopic: "{slug}/command"}
+	resolved := point.resolve(defaults)
+	if resolved.StateTopic != "{slug}/status" || resolved.CommandTopic != "{slug}/command" {
+		t.Errorf("Expected point state topic and default command topic, got %v\n", resolved)
+	}
+	resolved = TopicConfig{}.resolve(TopicConfig{})
+	if resolved.StateTopic != DefaultStateTopic || resolved.CommandTopic != DefaultCommandTopic || resolved.ErrorTopic != DefaultErrorTopic {
+		t.Errorf("Expected default topics, got %v\n", resolved)
+	}
+}
@@ -0,0 +1,155 @@
+package modbridge
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// NewClientHandler creates a modbus client handler out of a server URI.
+// Plain host:port or tcp:// URIs result in a TCP handler.
+// Serial URIs, like rtu:///dev/ttyUSB0?baud=19200&parity=E&stop=1 or ascii:///dev/ttyUSB0, result in a RTU or ASCII handler.
+// All of them accept a slave and timeout query parameter, where the slave defaults to 1 for serial lines.
+func NewClientHandler(uri string) (modbus.ClientHandler, error) {
+	// Plain host:port, kept for backwards compatibility
+	if !strings.Contains(uri, "://") {
+		return modbus.NewTCPClientHandler(uri), nil
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	query := parsed.Query()
+	// Slave 0 is the broadcast address, which no serial slave answers to, so serial lines default to the first slave
+	defaultSlave := 0
+	if parsed.Scheme == "rtu" || parsed.Scheme == "ascii" {
+		defaultSlave = 1
+	}
+	slaveID, err := queryInt(query, "slave", defaultSlave)
+	if err != nil {
+		return nil, err
+	}
+	if slaveID < 0 || slaveID > 255 {
+		return nil, fmt.Errorf("invalid slave %d", slaveID)
+	}
+	timeout, err := queryDuration(query, "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed.Scheme {
+	case "tcp":
+		handler := modbus.NewTCPClientHandler(parsed.Host)
+		handler.SlaveId = byte(slaveID)
+		if timeout > 0 {
+			handler.Timeout = timeout
+		}
+		return handler, nil
+	case "rtu":
+		handler := modbus.NewRTUClientHandler(parsed.Path)
+		handler.SlaveId = byte(slaveID)
+		if timeout > 0 {
+			handler.Timeout = timeout
+		}
+		err = configureSerial(&handler.BaudRate, &handler.DataBits, &handler.Parity, &handler.StopBits, query)
+		return handler, err
+	case "ascii":
+		handler := modbus.NewASCIIClientHandler(parsed.Path)
+		handler.SlaveId = byte(slaveID)
+		if timeout > 0 {
+			handler.Timeout = timeout
+		}
+		err = configureSerial(&handler.BaudRate, &handler.DataBits, &handler.Parity, &handler.StopBits, query)
+		return handler, err
+	}
+	return nil, fmt.Errorf("unsupported modbus scheme %q", parsed.Scheme)
+}
+
+// transportKey identifies the transport of a server URI, which is the same for all of the slaves on it, whatever their query parameters
+func transportKey(uri string) (string, error) {
+	if !strings.Contains(uri, "://") {
+		return uri, nil
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Scheme + "://" + parsed.Host + parsed.Path, nil
+}
+
+// ClientHandler creates the modbus client handler for the configured server URI.
+// The unit ID and timeout override the ones from the URI when set.
+func (c *Configuration) ClientHandler() (modbus.ClientHandler, error) {
+	handler, err := NewClientHandler(c.ModbusServerURI)
+	if err != nil {
+		return nil, err
+	}
+	switch h := handler.(type) {
+	case *modbus.TCPClientHandler:
+		configureHandler(&h.SlaveId, &h.Timeout, c.UnitID, c.Timeout)
+	case *modbus.RTUClientHandler:
+		configureHandler(&h.SlaveId, &h.Timeout, c.UnitID, c.Timeout)
+	case *modbus.ASCIIClientHandler:
+		configureHandler(&h.SlaveId, &h.Timeout, c.UnitID, c.Timeout)
+	}
+	return handler, nil
+}
+
+// configureHandler overrides the slave ID and timeout of a handler with the ones which are set
+func configureHandler(slaveID *byte, timeout *time.Duration, unitID byte, deviceTimeout time.Duration) {
+	if unitID != 0 {
+		*slaveID = unitID
+	}
+	if deviceTimeout > 0 {
+		*timeout = deviceTimeout
+	}
+}
+
+// configureSerial reads the serial line settings from the URI query, using the common 19200 8E1 as defaults
+func configureSerial(baudRate *int, dataBits *int, parity *string, stopBits *int, query url.Values) (err error) {
+	if *baudRate, err = queryInt(query, "baud", 19200); err != nil {
+		return
+	}
+	if *dataBits, err = queryInt(query, "data", 8); err != nil {
+		return
+	}
+	if *stopBits, err = queryInt(query, "stop", 1); err != nil {
+		return
+	}
+	*parity = strings.ToUpper(orDefault(query.Get("parity"), "E"))
+	if *parity != "N" && *parity != "E" && *parity != "O" {
+		return fmt.Errorf("invalid parity %q, should be N, E or O", *parity)
+	}
+	return
+}
+
+// queryInt reads an integer query parameter, returning the fallback if not set
+func queryInt(query url.Values, key string, fallback int) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+	return parsed, nil
+}
+
+// queryDuration reads a duration query parameter, returning zero if not set
+func queryDuration(query url.Values, key string) (time.Duration, error) {
+	value := query.Get(key)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+	return parsed, nil
+}
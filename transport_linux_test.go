@@ -0,0 +1,95 @@
+package modbridge
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/goburrow/modbus"
+)
+
+// openPty opens a pseudo terminal pair, returning the master side and the path of the slave side
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("No pseudo terminals available: %v", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Skipf("Unable to unlock pseudo terminal: %v", errno)
+	}
+	var number uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); errno != 0 {
+		master.Close()
+		t.Skipf("Unable to get pseudo terminal number: %v", errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
+
+func TestRTUClientOverPty(t *testing.T) {
+	master, path := openPty(t)
+	defer master.Close()
+
+	// Local stand-in for a RTU slave 5, answering a read coils request for 2 coils at address 4
+	request := []byte{0x05, 0x01, 0x00, 0x04, 0x00, 0x02}
+	response := []byte{0x05, 0x01, 0x01, 0x02}
+	done := make(chan error, 1)
+	go func() {
+		buffer := make([]byte, 8)
+		for n := 0; n < len(buffer); {
+			k, err := master.Read(buffer[n:])
+			if err != nil {
+				done <- err
+				return
+			}
+			n += k
+		}
+		if string(buffer[:6]) != string(request) {
+			done <- fmt.Errorf("unexpected request %x", buffer)
+			return
+		}
+		crc := crc16(response)
+		_, err := master.Write(append(response, byte(crc), byte(crc>>8)))
+		done <- err
+	}()
+
+	handler, err := NewClientHandler("rtu://" + path + "?baud=115200&slave=5&timeout=2s")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v\n", err)
+	}
+	results, err := modbus.NewClient(handler).ReadCoils(4, 2)
+	if err != nil {
+		t.Fatalf("Expected no error reading coils over the pty, got %v\n", err)
+	}
+	if len(results) != 1 || results[0] != 0x02 {
+		t.Errorf("Expected coil results 0x02, got %x\n", results)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Error in RTU stand-in: %v\n", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("RTU stand-in did not finish\n")
+	}
+}
+
+// crc16 computes the modbus RTU checksum
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for k := 0; k < 8; k++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
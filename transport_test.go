@@ -0,0 +1,98 @@
+package modbridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestNewClientHandlerTCP(t *testing.T) {
+	cases := []struct {
+		uri      string
+		address  string
+		slaveID  byte
+		timeout  time.Duration
+		hasError bool
+	}{
+		{uri: "unipi.lan:502", address: "unipi.lan:502", timeout: 10 * time.Second},
+		{uri: "tcp://unipi.lan:502?slave=3&timeout=500ms", address: "unipi.lan:502", slaveID: 3, timeout: 500 * time.Millisecond},
+		{uri: "tcp://unipi.lan:502?slave=300", hasError: true},
+		{uri: "tcp://unipi.lan:502?timeout=soon", hasError: true},
+		{uri: "udp://unipi.lan:502", hasError: true},
+	}
+	for _, testCase := range cases {
+		handler, err := NewClientHandler(testCase.uri)
+		if (err != nil) != testCase.hasError {
+			t.Errorf("Expected error %v for %s, got %v\n", testCase.hasError, testCase.uri, err)
+			continue
+		}
+		if testCase.hasError {
+			continue
+		}
+		tcpHandler, ok := handler.(*modbus.TCPClientHandler)
+		if !ok {
+			t.Errorf("Expected a TCP handler for %s, got %T\n", testCase.uri, handler)
+			continue
+		}
+		if tcpHandler.Address != testCase.address || tcpHandler.SlaveId != testCase.slaveID || tcpHandler.Timeout != testCase.timeout {
+			t.Errorf("Unexpected TCP handler settings for %s: %v %v %v\n", testCase.uri, tcpHandler.Address, tcpHandler.SlaveId, tcpHandler.Timeout)
+		}
+	}
+}
+
+func TestNewClientHandlerSerial(t *testing.T) {
+	handler, err := NewClientHandler("rtu:///dev/ttyUSB0?baud=9600&parity=n&stop=2&slave=7&timeout=2s")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v\n", err)
+	}
+	rtuHandler, ok := handler.(*modbus.RTUClientHandler)
+	if !ok {
+		t.Fatalf("Expected a RTU handler, got %T\n", handler)
+	}
+	if rtuHandler.Address != "/dev/ttyUSB0" || rtuHandler.BaudRate != 9600 || rtuHandler.Parity != "N" || rtuHandler.StopBits != 2 ||
+		rtuHandler.DataBits != 8 || rtuHandler.SlaveId != 7 || rtuHandler.Timeout != 2*time.Second {
+		t.Errorf("Unexpected RTU handler settings: %+v\n", rtuHandler.Config)
+	}
+
+	handler, err = NewClientHandler("ascii:///dev/ttyS1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v\n", err)
+	}
+	asciiHandler, ok := handler.(*modbus.ASCIIClientHandler)
+	if !ok {
+		t.Fatalf("Expected an ASCII handler, got %T\n", handler)
+	}
+	if asciiHandler.Address != "/dev/ttyS1" || asciiHandler.BaudRate != 19200 || asciiHandler.Parity != "E" || asciiHandler.StopBits != 1 ||
+		asciiHandler.SlaveId != 1 {
+		t.Errorf("Unexpected ASCII handler defaults: %+v\n", asciiHandler.Config)
+	}
+
+	if _, err = NewClientHandler("rtu:///dev/ttyUSB0?parity=X"); err == nil {
+		t.Errorf("Expected an error for an invalid parity\n")
+	}
+	if _, err = NewClientHandler("rtu:///dev/ttyUSB0?baud=fast"); err == nil {
+		t.Errorf("Expected an error for an invalid baud rate\n")
+	}
+}
+
+func TestConfigurationClientHandler(t *testing.T) {
+	c := Configuration{ModbusServerURI: "tcp://unipi.lan:502?slave=2&timeout=1s", UnitID: 5, Timeout: 3 * time.Second}
+	handler, err := c.ClientHandler()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v\n", err)
+	}
+	tcpHandler := handler.(*modbus.TCPClientHandler)
+	if tcpHandler.SlaveId != 5 || tcpHandler.Timeout != 3*time.Second {
+		t.Errorf("Expected unit ID and timeout to override the URI, got %v and %v\n", tcpHandler.SlaveId, tcpHandler.Timeout)
+	}
+
+	c = Configuration{ModbusServerURI: "rtu:///dev/ttyUSB0?slave=2"}
+	handler, err = c.ClientHandler()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v\n", err)
+	}
+	if rtuHandler := handler.(*modbus.RTUClientHandler); rtuHandler.SlaveId != 2 {
+		t.Errorf("Expected slave ID from the URI when no unit ID is set, got %v\n", rtuHandler.SlaveId)
+	}
+}